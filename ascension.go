@@ -0,0 +1,124 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+const ascensionFile string = "ascension.json" // in the configuration directory of the game
+
+// changes brought to the runs of the adventure by one ascension tier,
+// the changes of the tiers below it apply too
+type ascensionTier struct {
+	name    string // description shown when the tier is unlocked
+	maluses []int  // maluses given one level at the start of the runs
+	choices int    // maluses less offered after each level
+	levels  int    // levels added to the runs
+	coins   int    // percent of the score less converted into coins
+}
+
+// tiers in the order they are unlocked, the tier 0 is the plain adventure
+var ascensionTiers []ascensionTier = []ascensionTier{
+	{name: "RUNS START WITH\nA SPEED MALUS", maluses: []int{balanceSpeed}},
+	{name: "ONE MALUS LESS\nOFFERED AFTER LEVELS", choices: 1},
+	{name: "TWO MORE LEVELS\nTO COMPLETE", levels: 2},
+	{name: "25% LESS COINS\nAT THE END OF RUNS", coins: 25},
+	{name: "RUNS START WITH\nDEATH LINES", maluses: []int{balanceDeathLines}},
+	{name: "TWO MORE LEVELS\nTO COMPLETE", levels: 2},
+	{name: "RUNS START WITH\nHIDDEN LINES", maluses: []int{balanceHiddenLines}},
+	{name: "25% LESS COINS\nAT THE END OF RUNS", coins: 25},
+}
+
+// ascension tiers of a profile, saved with the tiers of the other profiles
+type ascension struct {
+	profile  string
+	unlocked int    // highest tier unlocked by winning
+	selected int    // tier of the next runs, up to the unlocked one
+	path     string // file where the tiers are saved, nothing is saved if empty
+}
+
+// read the tiers unlocked by a profile, none if they cannot be read
+func loadAscension(profile string) (a ascension) {
+	a.profile = profile
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return
+	}
+	a.path = filepath.Join(dir, "yatc", ascensionFile)
+	a.unlocked = min(a.readProfiles()[profile], len(ascensionTiers))
+	a.selected = a.unlocked
+	return
+}
+
+// unlocked tier of each profile saved in the file
+func (a ascension) readProfiles() (profiles map[string]int) {
+	profiles = map[string]int{}
+	if data, err := os.ReadFile(a.path); err == nil {
+		json.Unmarshal(data, &profiles)
+	}
+	return
+}
+
+// write the unlocked tier of the profile, errors are ignored: it is
+// then only kept until the game is closed
+func (a ascension) save() {
+	if a.path == "" {
+		return
+	}
+	profiles := a.readProfiles()
+	profiles[a.profile] = a.unlocked
+	data, err := json.Marshal(profiles)
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(a.path), 0o755) == nil {
+		os.WriteFile(a.path, data, 0o644)
+	}
+}
+
+// a run was won at the selected tier, the next one is unlocked and
+// selected if it exists, returns true in that case
+func (a *ascension) win() bool {
+	if a.selected < a.unlocked || a.unlocked >= len(ascensionTiers) {
+		return false
+	}
+	a.unlocked++
+	a.selected = a.unlocked
+	a.save()
+	return true
+}
+
+// change the selected tier, within the unlocked ones
+func (a *ascension) move(step int) {
+	a.selected = (a.selected + step + a.unlocked + 1) % (a.unlocked + 1)
+}
+
+// all the changes brought by the selected tier
+func (a ascension) rules() (rules ascensionTier) {
+	for _, tier := range ascensionTiers[:a.selected] {
+		rules.maluses = append(rules.maluses, tier.maluses...)
+		rules.choices += tier.choices
+		rules.levels += tier.levels
+		rules.coins += tier.coins
+	}
+	return
+}
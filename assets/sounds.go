@@ -35,6 +35,14 @@ import (
 var soundRotationBytes []byte
 var soundRotation []byte
 
+//go:embed rotationhalf.wav
+var soundRotationHalfBytes []byte
+var soundRotationHalf []byte
+
+//go:embed bomb.wav
+var soundBombBytes []byte
+var soundBomb []byte
+
 //go:embed leftright.wav
 var soundLeftRightBytes []byte
 var soundLeftRight []byte
@@ -96,6 +104,8 @@ const (
 	SoundDeathID
 	SoundMenuNoID
 	SoundRocketID
+	SoundRotationHalfID
+	SoundBombID
 	NumSounds
 )
 
@@ -160,6 +170,10 @@ func (s SoundManager) playSound(sound int) {
 		soundBytes = soundMenuNo
 	case SoundRocketID:
 		soundBytes = soundRocket
+	case SoundRotationHalfID:
+		soundBytes = soundRotationHalf
+	case SoundBombID:
+		soundBytes = soundBomb
 	}
 
 	if len(soundBytes) > 0 {
@@ -298,5 +312,23 @@ func InitAudio() (manager SoundManager) {
 		log.Panic("Audio problem:", error)
 	}
 
+	sound, error = wav.DecodeWithSampleRate(manager.audioContext.SampleRate(), bytes.NewReader(soundRotationHalfBytes))
+	if error != nil {
+		log.Panic("Audio problem:", error)
+	}
+	soundRotationHalf, error = io.ReadAll(sound)
+	if error != nil {
+		log.Panic("Audio problem:", error)
+	}
+
+	sound, error = wav.DecodeWithSampleRate(manager.audioContext.SampleRate(), bytes.NewReader(soundBombBytes))
+	if error != nil {
+		log.Panic("Audio problem:", error)
+	}
+	soundBomb, error = io.ReadAll(sound)
+	if error != nil {
+		log.Panic("Audio problem:", error)
+	}
+
 	return
 }
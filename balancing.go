@@ -19,6 +19,7 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 	"math"
@@ -26,6 +27,7 @@ import (
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
 	"github.com/loig/ebitenginegamejam2024/assets"
 )
 
@@ -35,29 +37,155 @@ const (
 	balanceHiddenLines
 	balanceDeathLines
 	balanceInvisibleBlocks
+	balanceWeirdPieces
+	balanceCurtainFog
+	balanceTopFog
+	balanceSpotlight
+	balanceMirror
+	balanceSwapRotations
+	balanceSlippery
+	balanceShrink
+	balanceSpecialSquares
 	numBalances
 )
 
 const (
 	maxLevelGoalLines       = 2
-	maxLevelSpeed           = 5
+	maxLevelSpeed           = 7
 	maxLevelHiddenLines     = 5
 	maxLevelDeathLines      = 5
 	maxLevelInvisibleBlocks = 3
+	maxLevelWeirdPieces     = 3
+	maxLevelCurtainFog      = 3
+	maxLevelTopFog          = 3
+	maxLevelSpotlight       = 3
+	maxLevelMirror          = 1
+	maxLevelSwapRotations   = 3
+	maxLevelSlippery        = 2
+	maxLevelShrink          = 3
+	maxLevelSpecialSquares  = 3
 )
 
+// position of the frame around the current choice in the malus image
+const malusFrameSprite int = 5
+
+// chance in percent, for each level of the malus, that a block is a weird piece
+const weirdPieceChance int = 15
+
+// chance in percent, for each level of the malus, that a block has a special square
+const specialSquareChance int = 10
+
+// special choices of the balancing screen
+const (
+	choiceReroll int = -2 - iota
+	choiceSkip
+)
+
+const skipPrice int = 30 // price in coins for skipping a malus
+
+// coin multiplier bonus, in percent, given by each level of a malus
+var malusRewards [numBalances]int = [numBalances]int{
+	balanceGoalLines:       15,
+	balanceSpeed:           10,
+	balanceHiddenLines:     10,
+	balanceDeathLines:      15,
+	balanceInvisibleBlocks: 20,
+	balanceWeirdPieces:     15,
+	balanceCurtainFog:      15,
+	balanceTopFog:          10,
+	balanceSpotlight:       20,
+	balanceMirror:          10,
+	balanceSwapRotations:   10,
+	balanceSlippery:        15,
+	balanceShrink:          20,
+	balanceSpecialSquares:  5,
+}
+
+// maluses that have no picture, drawn with their name and explained by their description
+var malusTexts map[int]struct {
+	name        string
+	description string
+} = map[int]struct {
+	name        string
+	description string
+}{
+	balanceWeirdPieces: {
+		name:        "WEIRD\nPIECES",
+		description: "SOME BLOCKS ARE REPLACED BY\nTROMINOES OR PENTOMINOES",
+	},
+	balanceCurtainFog: {
+		name:        "FOG\nCURTAIN",
+		description: "FOG HIDES COLUMNS\nON BOTH SIDES OF THE AREA",
+	},
+	balanceTopFog: {
+		name:        "TOP\nFOG",
+		description: "FOG HIDES LINES\nAT THE TOP OF THE AREA",
+	},
+	balanceSpotlight: {
+		name:        "SPOT\nLIGHT",
+		description: "ONLY THE SQUARES AROUND\nTHE FALLING BLOCK ARE VISIBLE",
+	},
+	balanceMirror: {
+		name:        "MIRROR",
+		description: "THE PLAY AREA IS SEEN\nIN A MIRROR",
+	},
+	balanceSwapRotations: {
+		name:        "SWAPPED\nROTATIONS",
+		description: "THE ROTATION KEYS ARE\nSWAPPED FROM TIME TO TIME",
+	},
+	balanceSlippery: {
+		name:        "SLIPPERY",
+		description: "BLOCKS KEEP SLIDING\nWHEN LEFT/RIGHT IS RELEASED",
+	},
+	balanceShrink: {
+		name:        "SHRINK",
+		description: "WALLS FILL COLUMNS\nON THE SIDES OF THE AREA",
+	},
+	balanceSpecialSquares: {
+		name:        "SPECIAL\nSQUARES",
+		description: "BLOCKS MAY HOLD BOMBS,\nCOINS OR CURSES",
+	},
+}
+
+var gMalusColor color.RGBA = color.RGBA{0xc0, 0x6c, 0x84, 0xff}
+
 type balancing struct {
 	levels          [numBalances]int
+	freeLevels      [numBalances]int // part of the levels given without coin reward, by the ascension tiers
 	maxLevels       [numBalances]int
 	choice          int
 	choiceDirection int
 	choices         []int
 	numChoices      int
+	items           []int // content of the carousel: maluses then special choices
+	rerolls         int   // number of rerolls left in this run
+	skips           int   // number of skips left in this run
+	coinBonus       int   // bonus on coin multiplier from improvements, in percent
+	boonOffered     bool  // a boon is offered after the malus of this level
+	inBoonDraft     bool  // the carousel currently contains boons
+	boonStacks      [numBoons]int
+	boonRemaining   [numBoons]int // number of levels left for each active boon
+	rng             *rand.Rand
 	inTransition    bool
 	transitionFrame int
 }
 
-func (b *balancing) update() (end bool, playSounds [assets.NumSounds]bool) {
+func (b *balancing) update(money *int) (end bool, playSounds [assets.NumSounds]bool) {
+	return b.updateWithInputs(
+		inpututil.IsKeyJustPressed(ebiten.KeyLeft),
+		inpututil.IsKeyJustPressed(ebiten.KeyRight),
+		inpututil.IsKeyJustPressed(ebiten.KeyEnter),
+		money,
+	)
+}
+
+// move in the carousel and select an element, with inputs given by the caller
+func (b *balancing) updateWithInputs(left, right, enter bool, money *int) (end bool, playSounds [assets.NumSounds]bool) {
+
+	// nothing to choose, the draft goes on without waiting for the player
+	if len(b.items) == 0 {
+		return b.selectItem(money)
+	}
 
 	if b.inTransition {
 		b.transitionFrame++
@@ -65,37 +193,84 @@ func (b *balancing) update() (end bool, playSounds [assets.NumSounds]bool) {
 			b.inTransition = false
 			b.transitionFrame = 0
 			if b.choiceDirection < 0 {
-				b.choice = (b.choice + 1) % b.numChoices
+				b.choice = (b.choice + 1) % len(b.items)
 			} else {
-				b.choice = (b.choice + b.numChoices - 1) % b.numChoices
+				b.choice = (b.choice + len(b.items) - 1) % len(b.items)
 			}
 		}
 		return
 	}
 
-	if inpututil.IsKeyJustPressed(ebiten.KeyLeft) {
+	if left {
 		playSounds[assets.SoundMenuMoveID] = true
 		b.choiceDirection = 1
 		b.inTransition = true
 	}
 
-	if inpututil.IsKeyJustPressed(ebiten.KeyRight) {
+	if right {
 		playSounds[assets.SoundMenuMoveID] = true
 		b.choiceDirection = -1
 		b.inTransition = true
 	}
 
-	end = inpututil.IsKeyJustPressed(ebiten.KeyEnter)
+	if !enter {
+		return
+	}
+
+	return b.selectItem(money)
+}
+
+// select the current element of the carousel
+func (b *balancing) selectItem(money *int) (end bool, playSounds [assets.NumSounds]bool) {
+
+	if !b.inBoonDraft && len(b.items) == 0 {
+		end = b.endMalusDraft()
+		return
+	}
 
-	if end {
-		b.setChoice(b.choices[b.choice])
+	if b.inBoonDraft {
+		b.setBoon(b.items[b.choice])
+		b.inBoonDraft = false
 		b.choice = 0
 		playSounds[assets.SoundMenuConfirmID] = true
+		end = true
+		return
 	}
 
+	switch b.items[b.choice] {
+	case choiceReroll:
+		b.rerolls--
+		b.getChoice(*money)
+		playSounds[assets.SoundMenuConfirmID] = true
+		return
+	case choiceSkip:
+		if *money < skipPrice {
+			playSounds[assets.SoundMenuNoID] = true
+			return
+		}
+		*money -= skipPrice
+		b.skips--
+		playSounds[assets.SoundBuyID] = true
+	default:
+		b.setChoice(b.items[b.choice])
+		playSounds[assets.SoundMenuConfirmID] = true
+	}
+
+	end = b.endMalusDraft()
 	return
 }
 
+// the malus of the level is chosen, go on with the boons if some are offered
+func (b *balancing) endMalusDraft() (end bool) {
+	b.choice = 0
+	if !b.boonOffered {
+		return true
+	}
+	b.getBoonChoice()
+	b.inBoonDraft = len(b.items) > 0
+	return !b.inBoonDraft
+}
+
 func drawLevel(screen *ebiten.Image, level, levelMax int, x, y float64) {
 
 	factor := 0.7
@@ -134,13 +309,53 @@ func drawLevel(screen *ebiten.Image, level, levelMax int, x, y float64) {
 	}
 }
 
+// draw one element of the carousel which top left corner is given by (x, y) in pixels
+func (b balancing) drawItem(screen *ebiten.Image, item int, x, y float64, gray uint8) {
+
+	if b.inBoonDraft {
+		center := float32(gChoiceSize) / 2
+		vector.DrawFilledCircle(screen, float32(x)+center, float32(y)+center, 0.45*float32(gChoiceSize), scaleColor(gBoonColor, gray), true)
+		drawTextCentered(screen, boonDefinitions[item].name, int(x)+gChoiceSize/2, int(y)+2*gChoiceSize/5, gTextScale, scaleColor(gTextColor, gray))
+		drawLevel(screen, b.boonStacks[item], boonDefinitions[item].maxStack, x, y)
+		return
+	}
+
+	if item < 0 {
+		center := float32(gChoiceSize) / 2
+		vector.DrawFilledCircle(screen, float32(x)+center, float32(y)+center, 0.45*float32(gChoiceSize), scaleColor(gTextLightColor, gray), true)
+		label := ""
+		switch item {
+		case choiceReroll:
+			label = fmt.Sprintf("REROLL\n%d LEFT", b.rerolls)
+		case choiceSkip:
+			label = fmt.Sprintf("SKIP\n%d LEFT", b.skips)
+		}
+		drawTextCentered(screen, label, int(x)+gChoiceSize/2, int(y)+gChoiceSize/2, gTextScale, scaleColor(gTextColor, gray))
+		return
+	}
+
+	if text, ok := malusTexts[item]; ok {
+		center := float32(gChoiceSize) / 2
+		vector.DrawFilledCircle(screen, float32(x)+center, float32(y)+center, 0.45*float32(gChoiceSize), scaleColor(gMalusColor, gray), true)
+		drawTextCentered(screen, text.name, int(x)+gChoiceSize/2, int(y)+2*gChoiceSize/5, gTextScale, scaleColor(gTextColor, gray))
+		drawLevel(screen, b.levels[item], b.maxLevels[item], x, y)
+		return
+	}
+
+	options := ebiten.DrawImageOptions{}
+	options.ColorScale.ScaleWithColor(color.Gray{gray})
+	options.GeoM.Translate(x, y)
+	screen.DrawImage(assets.ImageMalus.SubImage(image.Rect(item*gChoiceSize, 0, (item+1)*gChoiceSize, gChoiceSize)).(*ebiten.Image), &options)
+	drawLevel(screen, b.levels[item], b.maxLevels[item], x, y)
+}
+
 func (b balancing) drawChoices(screen *ebiten.Image, cX, cY int) {
 
 	r := float64(gHeight / 7)
 	var gray uint8 = 200
 	currentGray := gray
 
-	angleShift := float64(b.choiceDirection) * float64(b.transitionFrame) / float64(gChoiceSelectionNumFrame) * (math.Pi * 2) / float64(b.numChoices)
+	angleShift := float64(b.choiceDirection) * float64(b.transitionFrame) / float64(gChoiceSelectionNumFrame) * (math.Pi * 2) / float64(len(b.items))
 
 	if !b.inTransition {
 		angleShift = 0
@@ -152,32 +367,25 @@ func (b balancing) drawChoices(screen *ebiten.Image, cX, cY int) {
 	currentY += float64(cY - gChoiceSize/2)
 
 	// current choice
-	options := ebiten.DrawImageOptions{}
-	options.ColorScale.ScaleWithColor(color.Gray{currentGray})
-	options.GeoM.Translate(currentX, currentY)
 	if !b.inTransition {
-		screen.DrawImage(assets.ImageMalus.SubImage(image.Rect(numBalances*gChoiceSize, 0, (numBalances+1)*gChoiceSize, gChoiceSize)).(*ebiten.Image), &options)
+		options := ebiten.DrawImageOptions{}
+		options.GeoM.Translate(currentX, currentY)
+		screen.DrawImage(assets.ImageMalus.SubImage(image.Rect(malusFrameSprite*gChoiceSize, 0, (malusFrameSprite+1)*gChoiceSize, gChoiceSize)).(*ebiten.Image), &options)
 	}
-	screen.DrawImage(assets.ImageMalus.SubImage(image.Rect(b.choices[b.choice]*gChoiceSize, 0, (b.choices[b.choice]+1)*gChoiceSize, gChoiceSize)).(*ebiten.Image), &options)
-	drawLevel(screen, b.levels[b.choices[b.choice]], b.maxLevels[b.choices[b.choice]], currentX, currentY)
+	b.drawItem(screen, b.items[b.choice], currentX, currentY, currentGray)
 
 	// other choices
-	for i := 0; i < b.numChoices-1; i++ {
+	for i := 0; i < len(b.items)-1; i++ {
 		// find the choice to display
-		displayNum := (b.choice + i + 1) % b.numChoices
-		theChoice := b.choices[displayNum]
+		displayNum := (b.choice + i + 1) % len(b.items)
 
 		//find the position to display it
-		angle := float64(i+1)*(math.Pi*2)/float64(b.numChoices) + math.Pi/2 + angleShift
+		angle := float64(i+1)*(math.Pi*2)/float64(len(b.items)) + math.Pi/2 + angleShift
 		x, y := math.Cos(angle)*r, -math.Sin(angle)*r
 		x += float64(cX - gChoiceSize/2)
 		y += float64(cY - gChoiceSize/2)
 
-		options := ebiten.DrawImageOptions{}
-		options.ColorScale.ScaleWithColor(color.Gray{gray})
-		options.GeoM.Translate(x, y)
-		screen.DrawImage(assets.ImageMalus.SubImage(image.Rect(theChoice*gChoiceSize, 0, (theChoice+1)*gChoiceSize, gChoiceSize)).(*ebiten.Image), &options)
-		drawLevel(screen, b.levels[theChoice], b.maxLevels[theChoice], x, y)
+		b.drawItem(screen, b.items[displayNum], x, y, gray)
 	}
 
 }
@@ -188,17 +396,50 @@ func (b balancing) draw(screen *ebiten.Image) {
 	options.GeoM.Translate(float64(gWidth-gLevelCompleteWidth)/2, float64(gTitleMargin))
 	screen.DrawImage(assets.ImageLevelComplete, &options)
 
+	if len(b.items) == 0 {
+		return
+	}
+
 	b.drawChoices(screen, gWidth/2, gHeight/2-30)
 
-	options = ebiten.DrawImageOptions{}
-	options.GeoM.Translate(float64(gWidth-gTextMalusWidth)/2, float64(gHeight-gTextMalusHeight))
-	id := b.choices[b.choice]
-	screen.DrawImage(assets.ImageTextMalus.SubImage(image.Rect(0, id*gTextMalusHeight, gTextMalusWidth, (id+1)*gTextMalusHeight)).(*ebiten.Image), &options)
+	x, y := (gWidth-gTextMalusWidth)/2, gHeight-gTextMalusHeight
+	id := b.items[b.choice]
+
+	// boons do not change the coins and share their ids with maluses
+	if b.inBoonDraft {
+		drawTextBanner(screen, boonDefinitions[id].description, x, y, gTextMalusWidth, gTextMalusHeight)
+		return
+	}
+
+	multiplier := "COINS " + formatMultiplier(b.getMultiplier())
+	if id >= 0 {
+		multiplier += " > " + formatMultiplier(b.getMultiplier()+malusRewards[id])
+	}
+	drawTextCentered(screen, multiplier, gWidth/2, y-gTitleMargin-gTextCharHeight*int(gTextScale)/2, gTextScale, gTextLightColor)
+	switch id {
+	case choiceReroll:
+		drawTextBanner(screen, "DRAW A NEW SET OF MALUSES", x, y, gTextMalusWidth, gTextMalusHeight)
+	case choiceSkip:
+		drawTextBanner(screen, fmt.Sprintf("PAY %d COINS TO SKIP\nTHE MALUS OF THIS LEVEL", skipPrice), x, y, gTextMalusWidth, gTextMalusHeight)
+	default:
+		if text, ok := malusTexts[id]; ok {
+			drawTextBanner(screen, text.description, x, y, gTextMalusWidth, gTextMalusHeight)
+			break
+		}
+		options = ebiten.DrawImageOptions{}
+		options.GeoM.Translate(float64(x), float64(y))
+		screen.DrawImage(assets.ImageTextMalus.SubImage(image.Rect(0, id*gTextMalusHeight, gTextMalusWidth, (id+1)*gTextMalusHeight)).(*ebiten.Image), &options)
+	}
 }
 
-func newBalance(numChoices int) balancing {
+func newBalance(numChoices int, effects improvementEffects) balancing {
 
-	b := balancing{}
+	b := balancing{
+		rerolls:   effects.rerolls,
+		skips:     effects.skips,
+		coinBonus: effects.coinBonus,
+		rng:       newRandom(),
+	}
 
 	b.choices = make([]int, numChoices)
 	for i := range b.choices {
@@ -210,10 +451,30 @@ func newBalance(numChoices int) balancing {
 	b.maxLevels[balanceHiddenLines] = maxLevelHiddenLines
 	b.maxLevels[balanceDeathLines] = maxLevelDeathLines
 	b.maxLevels[balanceInvisibleBlocks] = maxLevelInvisibleBlocks
+	b.maxLevels[balanceWeirdPieces] = maxLevelWeirdPieces
+	b.maxLevels[balanceCurtainFog] = maxLevelCurtainFog
+	b.maxLevels[balanceTopFog] = maxLevelTopFog
+	b.maxLevels[balanceSpotlight] = maxLevelSpotlight
+	b.maxLevels[balanceMirror] = maxLevelMirror
+	b.maxLevels[balanceSwapRotations] = maxLevelSwapRotations
+	b.maxLevels[balanceSlippery] = maxLevelSlippery
+	b.maxLevels[balanceShrink] = maxLevelShrink
+	b.maxLevels[balanceSpecialSquares] = maxLevelSpecialSquares
 	return b
 }
 
-func (b *balancing) getChoice() {
+// end of a level: make the boons age and draw the maluses offered,
+// money is what is available for skipping
+func (b *balancing) startDraft(level, money int) {
+	b.endLevel()
+	b.boonOffered = isBoonLevel(level)
+	b.inBoonDraft = false
+	b.getChoice(money)
+}
+
+// draw the maluses offered, rerolls and skips are only offered when
+// there are maluses left and skips only when they can be paid
+func (b *balancing) getChoice(money int) {
 
 	possibleChoices := make([]int, 0, 2*numBalances)
 
@@ -232,7 +493,7 @@ BalanceLoop:
 
 	choice := 0
 	for ; len(possibleChoices) > 0 && choice < len(b.choices); choice++ {
-		take := rand.Intn(len(possibleChoices))
+		take := b.rng.Intn(len(possibleChoices))
 		b.choices[choice] = possibleChoices[take]
 
 		possibleChoices = removeElement(possibleChoices, take)
@@ -256,14 +517,30 @@ BalanceLoop:
 		b.choices[choice] = -1
 	}
 
+	b.items = append(b.items[:0], b.choices[:b.numChoices]...)
+	if b.numChoices > 0 && b.rerolls > 0 {
+		b.items = append(b.items, choiceReroll)
+	}
+	if b.numChoices > 0 && b.skips > 0 && money >= skipPrice {
+		b.items = append(b.items, choiceSkip)
+	}
+	b.choice = 0
+
 }
 
 func (b *balancing) setChoice(choice int) {
 	b.levels[choice]++
 }
 
-func (b balancing) getDeathLines() (numLines int) {
-	const maxDeathLines int = 2*gPlayAreaHeightInBlocks/3 - 1
+// raise a malus without raising the coin multiplier
+func (b *balancing) imposeMalus(malus int) {
+	b.levels[malus]++
+	b.freeLevels[malus]++
+}
+
+// number of death lines on a play area of a given height, in squares
+func (b balancing) getDeathLines(height int) (numLines int) {
+	maxDeathLines := 2*height/3 - 1
 
 	numLines = 2*b.levels[balanceDeathLines] + 1
 	if numLines > maxDeathLines {
@@ -281,6 +558,11 @@ func (b balancing) getHiddenLines() (numLines int) {
 		numLines = maxHiddenLines
 	}
 
+	numLines -= b.boonStacks[boonFogShrink] * boonHiddenLinesPerStack
+	if numLines < 0 {
+		numLines = 0
+	}
+
 	return
 }
 
@@ -297,7 +579,7 @@ func (b balancing) getGoalLines() int {
 
 func (b balancing) getSpeedLevel(baseSpeedLevel int) int {
 	var speedLevels [maxLevelSpeed]int = [maxLevelSpeed]int{
-		1, 2, 4, 7, 10,
+		1, 2, 4, 7, 10, 14, 18,
 	}
 
 	id := b.levels[balanceSpeed]
@@ -305,14 +587,73 @@ func (b balancing) getSpeedLevel(baseSpeedLevel int) int {
 		id = len(speedLevels) - 1
 	}
 	baseSpeedLevel += speedLevels[id]
+	baseSpeedLevel -= b.boonStacks[boonSlowGravity] * boonSpeedLevelsPerStack
 
-	if baseSpeedLevel >= gSpeedLevels {
-		baseSpeedLevel = gSpeedLevels - 1
+	// there is no upper limit, the gravity curve gives its last speed above its end
+	if baseSpeedLevel < 0 {
+		baseSpeedLevel = 0
 	}
 
 	return baseSpeedLevel
 }
 
+// get the coin multiplier, in percent, earned with the maluses chosen
+func (b balancing) getMultiplier() (multiplier int) {
+	multiplier = 100 + b.coinBonus
+	for malus, level := range b.levels {
+		multiplier += (level - b.freeLevels[malus]) * malusRewards[malus]
+	}
+	return
+}
+
 func (b balancing) getInvisibleBlocks() int {
 	return b.levels[balanceInvisibleBlocks]
 }
+
+func (b balancing) getWeirdPieces() int {
+	return b.levels[balanceWeirdPieces]
+}
+
+// number of columns hidden on each side of the play area
+func (b balancing) getCurtainColumns() int {
+	return b.levels[balanceCurtainFog]
+}
+
+// number of lines hidden at the top of the play area
+func (b balancing) getTopFogLines() int {
+	const topFogFactor int = 3
+	return topFogFactor * b.levels[balanceTopFog]
+}
+
+// radius of the spotlight removed from its largest one, in squares
+func (b balancing) getSpotlightDarkness() int {
+	return b.levels[balanceSpotlight]
+}
+
+func (b balancing) getMirror() bool {
+	return b.levels[balanceMirror] > 0
+}
+
+// number of frames between two swaps of the rotation keys, 0 for no swap
+func (b balancing) getSwapRotationsPeriod() int {
+	var periods [maxLevelSwapRotations + 1]int = [maxLevelSwapRotations + 1]int{
+		0, 1200, 600, 300,
+	}
+
+	return periods[min(b.levels[balanceSwapRotations], maxLevelSwapRotations)]
+}
+
+// number of squares a block slides after left/right is released
+func (b balancing) getSlipperiness() int {
+	return b.levels[balanceSlippery]
+}
+
+// number of columns of the play area filled with walls
+func (b balancing) getWallColumns() int {
+	return b.levels[balanceShrink]
+}
+
+// chance in percent that a block has a special square
+func (b balancing) getSpecialSquareChance() int {
+	return b.levels[balanceSpecialSquares] * specialSquareChance
+}
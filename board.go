@@ -0,0 +1,53 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import "fmt"
+
+const (
+	boardMinSize   int = 4  // minimum width and height of a play area, for blocks to fit
+	boardMaxWidth  int = 30 // maximum width of a play area in squares
+	boardMaxHeight int = 30 // maximum height of a play area in squares, without the invisible lines
+)
+
+// size of a play area in squares, without the invisible lines
+type boardSize struct {
+	name   string
+	width  int
+	height int
+}
+
+// play areas that can be chosen for the adventure
+var boardSizes []boardSize = []boardSize{
+	{name: "CLASSIC 10X18", width: gPlayAreaWidthInBlocks, height: gPlayAreaHeightInBlocks},
+	{name: "GUIDELINE 10X20", width: 10, height: 20},
+	{name: "WIDE 12X18", width: 12, height: 18},
+	{name: "NARROW 4X18", width: 4, height: 18},
+}
+
+// check that a play area of a given size can be played
+func checkBoardSize(width, height int) error {
+	if width < boardMinSize || width > boardMaxWidth {
+		return fmt.Errorf("width %d out of range [%d, %d]", width, boardMinSize, boardMaxWidth)
+	}
+	if height < boardMinSize || height > boardMaxHeight {
+		return fmt.Errorf("height %d out of range [%d, %d]", height, boardMinSize, boardMaxHeight)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+const (
+	boonSlowGravity int = iota
+	boonFreeHold
+	boonExtraHeart
+	boonLineBomb
+	boonFogShrink
+	numBoons
+)
+
+const (
+	boonEvery int = 2 // boons are offered every boonEvery levels
+
+	boonSpeedLevelsPerStack int = 2 // speed levels removed by each stack of slow gravity
+	boonHiddenLinesPerStack int = 3 // hidden lines removed by each stack of fog shrink
+	boonBombLinesPerStack   int = 2 // bottom lines removed by each stack of line bomb
+	boonExtraHeartsPerStack int = 1 // hearts given by each stack of extra heart
+	boonNoDuration          int = 1 // boons applied only at the start of the next level
+)
+
+// description of a positive modifier that can be chosen between levels
+type boonDefinition struct {
+	name        string
+	description string
+	duration    int // number of levels the boon lasts once chosen
+	maxStack    int // number of times the boon can be stacked while active
+}
+
+var boonDefinitions [numBoons]boonDefinition = [numBoons]boonDefinition{
+	boonSlowGravity: {
+		name:        "SLOW\nGRAVITY",
+		description: "TETROMINOES FALL SLOWER\nFOR THE NEXT TWO LEVELS",
+		duration:    2, maxStack: 3,
+	},
+	boonFreeHold: {
+		name:        "FREE\nHOLD",
+		description: "HOLD A TETROMINO WITH THE UP\nARROW FOR THE NEXT THREE LEVELS",
+		duration:    3, maxStack: 1,
+	},
+	boonExtraHeart: {
+		name:        "EXTRA\nHEART",
+		description: "GAIN AN EXTRA HEART\nFOR THE NEXT THREE LEVELS",
+		duration:    3, maxStack: 2,
+	},
+	boonLineBomb: {
+		name:        "LINE\nBOMB",
+		description: "CLEAR THE TWO BOTTOM LINES\nAT THE START OF THE NEXT LEVEL",
+		duration:    boonNoDuration, maxStack: 1,
+	},
+	boonFogShrink: {
+		name:        "FOG\nSHRINK",
+		description: "REDUCE THE HEIGHT OF THE FOG\nFOR THE NEXT TWO LEVELS",
+		duration:    2, maxStack: 3,
+	},
+}
+
+// check if boons should be offered after a given level
+func isBoonLevel(level int) bool {
+	return (level+1)%boonEvery == 0
+}
+
+// draw the boons offered, among those that can still be stacked
+func (b *balancing) getBoonChoice() {
+
+	possibleChoices := make([]int, 0, numBoons)
+	for boon := 0; boon < numBoons; boon++ {
+		if b.boonStacks[boon] < boonDefinitions[boon].maxStack {
+			possibleChoices = append(possibleChoices, boon)
+		}
+	}
+
+	b.items = b.items[:0]
+	for len(possibleChoices) > 0 && len(b.items) < len(b.choices) {
+		take := b.rng.Intn(len(possibleChoices))
+		b.items = append(b.items, possibleChoices[take])
+		possibleChoices = removeElement(possibleChoices, take)
+	}
+	b.choice = 0
+}
+
+func (b *balancing) setBoon(boon int) {
+	b.boonStacks[boon]++
+	b.boonRemaining[boon] = boonDefinitions[boon].duration
+}
+
+// make the active boons age by one level
+func (b *balancing) endLevel() {
+	for boon := range b.boonRemaining {
+		if b.boonRemaining[boon] > 0 {
+			b.boonRemaining[boon]--
+			if b.boonRemaining[boon] <= 0 {
+				b.boonStacks[boon] = 0
+			}
+		}
+	}
+}
+
+func (b balancing) hasBoon(boon int) bool {
+	return b.boonStacks[boon] > 0
+}
+
+func (b balancing) getExtraHearts() int {
+	return b.boonStacks[boonExtraHeart] * boonExtraHeartsPerStack
+}
+
+// modify the effects of improvements for the next level according to active boons
+func (b balancing) applyBoons(effects improvementEffects) improvementEffects {
+	effects.canHold = effects.canHold || b.hasBoon(boonFreeHold)
+	if extraHearts := b.getExtraHearts(); extraHearts > 0 {
+		effects.life = max(effects.life, 0) + extraHearts
+	}
+	return effects
+}
+
+func (b balancing) getBombLines() int {
+	return b.boonStacks[boonLineBomb] * boonBombLinesPerStack
+}
@@ -0,0 +1,401 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// inputs produced by the bot, equivalent to the keys of a player
+type botInputs struct {
+	down, left, right, hold, rotateLeft, rotateRight bool
+}
+
+// state of the grid after a possible placement, evaluated by the heuristic
+type botBoard struct {
+	grid         tetrisGrid // without the walls, for evaluating the board
+	area         tetrisGrid // with the walls, for placing the next blocks
+	heights      []int      // height of each column, in squares
+	linesCleared int
+	deathLines   int
+}
+
+// one feature of the heuristic with its weight
+type botFeature struct {
+	name   string
+	weight float64
+	eval   func(b botBoard) float64
+}
+
+// a heuristic is a weighted sum of features
+type botHeuristic []botFeature
+
+var defaultBotHeuristic botHeuristic = botHeuristic{
+	{name: "height", weight: -0.51, eval: botAggregateHeight},
+	{name: "lines", weight: 0.76, eval: botLinesCleared},
+	{name: "holes", weight: -0.36, eval: botHoles},
+	{name: "bumpiness", weight: -0.18, eval: botBumpiness},
+	{name: "danger", weight: -2, eval: botDanger},
+}
+
+// a possible placement of the current block
+type botPlacement struct {
+	hold  bool
+	block tetrisBlock // the block placed, at its final position
+	score float64
+}
+
+type bot struct {
+	heuristic botHeuristic
+	plan      botPlacement
+	planned   bool
+	planPiece int // value of tetris.pieces when the plan was made
+	holdDone  bool
+	rotations int
+	frame     int
+	held      int // direction of the left/right key held on a slippery area
+}
+
+func newBot(heuristic botHeuristic) bot {
+	return bot{heuristic: heuristic}
+}
+
+// get the inputs to send to the tetris game for the current frame,
+// taking the maluses on the controls into account
+func (b *bot) update(t tetris) (inputs botInputs) {
+	inputs = b.choose(t)
+	if t.mirrored {
+		inputs.left, inputs.right = inputs.right, inputs.left
+	}
+	if t.mirrored != t.rotationsSwapped {
+		inputs.rotateLeft, inputs.rotateRight = inputs.rotateRight, inputs.rotateLeft
+	}
+	return
+}
+
+// get the inputs for the current frame as if the controls were not changed by maluses
+func (b *bot) choose(t tetris) (inputs botInputs) {
+
+	if t.dead || t.inAnimation {
+		b.planned = false
+		return
+	}
+
+	if !b.planned || b.planPiece != t.pieces {
+		b.plan = b.choosePlacement(t, t.canHold)
+		b.planned = true
+		b.planPiece = t.pieces
+		b.holdDone = false
+		b.rotations = 0
+		b.frame = 0
+		b.held = 0
+		// release all keys so that manual moves are allowed
+		return
+	}
+
+	b.frame++
+
+	if b.plan.hold && !b.holdDone {
+		b.holdDone = true
+		inputs.hold = true
+		return
+	}
+
+	if b.plan.hold && t.currentBlock.id != b.plan.block.id {
+		// the hold failed, plan again without holding
+		b.plan = b.choosePlacement(t, false)
+		b.rotations = 0
+	}
+
+	if t.currentBlock.r != b.plan.block.r && b.rotations < 4 {
+		b.rotations++
+		if (t.currentBlock.r+3)%4 == b.plan.block.r {
+			inputs.rotateLeft = true
+		} else {
+			inputs.rotateRight = true
+		}
+		return
+	}
+
+	if t.slipperiness > 0 {
+		if b.slide(t, &inputs) {
+			return
+		}
+	} else if t.currentBlock.x != b.plan.block.x {
+		// left/right keys must be released between moves to avoid auto repeat delay
+		if b.frame%2 == 0 {
+			inputs.left = t.currentBlock.x > b.plan.block.x
+			inputs.right = t.currentBlock.x < b.plan.block.x
+		}
+		return
+	}
+
+	inputs.down = true
+	return
+}
+
+// on a slippery area the block ends slipperiness squares further than
+// where it is when the key is released, so the key is held until the
+// block is that far from its goal, going the other way first when the
+// goal is too close, returns true while the block is not in place
+func (b *bot) slide(t tetris, inputs *botInputs) bool {
+	goal := b.plan.block.x
+	x := t.currentBlock.x + t.slideLeft*t.slideDirection
+	if x == goal {
+		b.held = 0
+		return t.slideLeft > 0
+	}
+
+	toward := 1
+	if x > goal {
+		toward = -1
+	}
+	switch {
+	case b.held == toward:
+	case b.held == -toward && (goal-t.currentBlock.x)*toward <= t.slipperiness:
+	case b.held != 0:
+		// release the key before changing direction
+		b.held = 0
+	case (goal-t.currentBlock.x)*toward > t.slipperiness:
+		b.held = toward
+	default:
+		b.held = -toward
+	}
+
+	inputs.left = b.held < 0
+	inputs.right = b.held > 0
+	return true
+}
+
+// enumerate the reachable placements and choose the best one
+func (b bot) choosePlacement(t tetris, canHold bool) (best botPlacement) {
+
+	best.score = math.Inf(-1)
+	best.block = t.currentBlock
+
+	candidates := []tetrisBlock{t.currentBlock}
+	if canHold {
+		held := t.heldBlock
+		if held.id < 0 {
+			held = t.nextBlock
+		}
+		if canReplace(t.currentBlock.x, t.currentBlock.y, t.heldBlock, t.nextBlock, t.area) {
+			held.x, held.y, held.r = t.currentBlock.x, t.currentBlock.y, 0
+			candidates = append(candidates, held)
+		}
+	}
+
+	for candidateNum, block := range candidates {
+		// the block coming after this one, if it is known
+		lookahead := t.nextBlock
+		if candidateNum > 0 && t.heldBlock.id < 0 {
+			lookahead = tetrisBlock{id: -1}
+			if len(t.previews) > 0 {
+				lookahead = t.previews[0]
+			}
+		}
+		lookahead.setInitialPosition(t.spawnX)
+
+		for _, placement := range getReachablePlacements(block, t.area) {
+			board := getBotBoard(placement, t.area, t.deathLines)
+			score := b.evaluateWithLookahead(board, lookahead)
+			if score > best.score {
+				best = botPlacement{hold: candidateNum > 0, block: placement, score: score}
+			}
+		}
+	}
+
+	return
+}
+
+// evaluate a board by the best placement of the block coming next
+func (b bot) evaluateWithLookahead(board botBoard, next tetrisBlock) (best float64) {
+	best = math.Inf(-1)
+	if next.id >= 0 {
+		for _, placement := range getReachablePlacements(next, board.area) {
+			nextBoard := getBotBoard(placement, board.area, board.deathLines)
+			nextBoard.linesCleared += board.linesCleared
+			if score := b.heuristic.evaluate(nextBoard); score > best {
+				best = score
+			}
+		}
+	}
+	if math.IsInf(best, -1) {
+		// the next block is unknown or cannot be placed
+		best = b.heuristic.evaluate(board)
+	}
+	return
+}
+
+// get the final positions reachable by rotating the block at its current
+// position, then moving it left or right, then dropping it
+func getReachablePlacements(block tetrisBlock, grid tetrisGrid) (placements []tetrisBlock) {
+
+	triedStates := make([][blockSize][blockSize]bool, 0, 4)
+
+RotationLoop:
+	for rotation := 0; rotation < 4; rotation++ {
+		if rotation > 0 && !block.rotateRight(grid) {
+			return
+		}
+		for _, state := range triedStates {
+			if state == block.states[block.r] {
+				continue RotationLoop
+			}
+		}
+		triedStates = append(triedStates, block.states[block.r])
+
+		for _, direction := range []int{-1, 1} {
+			moved := block
+			for {
+				placed := moved
+				for !placed.moveDown(grid) {
+				}
+				if direction < 0 || moved.x != block.x {
+					placements = append(placements, placed)
+				}
+				if direction < 0 && !moved.moveLeft(grid) {
+					break
+				}
+				if direction > 0 && !moved.moveRight(grid) {
+					break
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// compute the grid obtained with a given placement
+func getBotBoard(placement tetrisBlock, grid tetrisGrid, deathLines int) (board botBoard) {
+
+	board.deathLines = deathLines
+	grid = grid.clone()
+	placement.writeInGrid(grid)
+	walls := grid[len(grid)-1]
+
+	// remove the complete lines
+	y := len(grid) - 1
+	for line := len(grid) - 1; line >= 0; line-- {
+		complete := true
+		for _, square := range grid[line] {
+			if square == noStyle {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			board.linesCleared++
+			continue
+		}
+		grid[y] = grid[line]
+		y--
+	}
+	for ; y >= 0; y-- {
+		grid[y] = make(tetrisLine, grid.width())
+		for x, style := range walls {
+			if style == wallStyle {
+				grid[y][x] = wallStyle
+			}
+		}
+	}
+	board.area = grid
+	grid = withoutWalls(grid)
+	board.grid = grid
+
+	board.heights = make([]int, len(grid[0]))
+	for x := range board.heights {
+		for y := range grid {
+			if grid[y][x] != noStyle {
+				board.heights[x] = len(grid) - y
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// the grid without the columns filled with walls by the shrink malus,
+// which would otherwise count as very high columns
+func withoutWalls(grid tetrisGrid) tetrisGrid {
+	bottom := grid[len(grid)-1]
+	if !slices.Contains(bottom, wallStyle) {
+		return grid
+	}
+	stripped := make(tetrisGrid, len(grid))
+	for y, line := range grid {
+		stripped[y] = make(tetrisLine, 0, len(line))
+		for x, style := range line {
+			if bottom[x] != wallStyle {
+				stripped[y] = append(stripped[y], style)
+			}
+		}
+	}
+	return stripped
+}
+
+func (h botHeuristic) evaluate(board botBoard) (score float64) {
+	for _, feature := range h {
+		score += feature.weight * feature.eval(board)
+	}
+	return
+}
+
+func botAggregateHeight(b botBoard) (height float64) {
+	for _, h := range b.heights {
+		height += float64(h)
+	}
+	return
+}
+
+func botLinesCleared(b botBoard) float64 {
+	return float64(b.linesCleared)
+}
+
+func botHoles(b botBoard) (holes float64) {
+	for x, h := range b.heights {
+		for y := len(b.grid) - h; y < len(b.grid); y++ {
+			if b.grid[y][x] == noStyle {
+				holes++
+			}
+		}
+	}
+	return
+}
+
+func botBumpiness(b botBoard) (bumpiness float64) {
+	for x := 1; x < len(b.heights); x++ {
+		bumpiness += math.Abs(float64(b.heights[x] - b.heights[x-1]))
+	}
+	return
+}
+
+// how much the highest column gets close to or in the death zone
+func botDanger(b botBoard) (danger float64) {
+	safeHeight := len(b.grid) - gInvisibleLines - b.deathLines - 2
+	for _, h := range b.heights {
+		if h > safeHeight {
+			danger += float64(h - safeHeight)
+		}
+	}
+	return
+}
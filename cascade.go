@@ -0,0 +1,120 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import "sort"
+
+// squares of the area that are connected through their sides, as (x, y) positions
+type squareGroup [][2]int
+
+// lowest line reached by a group
+func (g squareGroup) bottom() (y int) {
+	for _, square := range g {
+		y = max(y, square[1])
+	}
+	return
+}
+
+// groups of connected squares of the grid, walls excepted,
+// from the one reaching the lowest line to the one reaching the highest
+func (grid tetrisGrid) groups() (groups []squareGroup) {
+	seen := make([][]bool, len(grid))
+	for y := range seen {
+		seen[y] = make([]bool, grid.width())
+	}
+
+	for y, line := range grid {
+		for x, style := range line {
+			if style == noStyle || style == wallStyle || seen[y][x] {
+				continue
+			}
+			seen[y][x] = true
+			group := squareGroup{{x, y}}
+			for next := 0; next < len(group); next++ {
+				for _, side := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+					xx, yy := group[next][0]+side[0], group[next][1]+side[1]
+					if yy < 0 || yy >= len(grid) || xx < 0 || xx >= len(line) || seen[yy][xx] {
+						continue
+					}
+					if grid[yy][xx] != noStyle && grid[yy][xx] != wallStyle {
+						seen[yy][xx] = true
+						group = append(group, [2]int{xx, yy})
+					}
+				}
+			}
+			groups = append(groups, group)
+		}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].bottom() > groups[j].bottom()
+	})
+	return
+}
+
+// make the groups of connected squares fall until they rest on other
+// squares, walls or the bottom of the area (cascade gravity), groups
+// landing on each other stick together for the next falls
+func (t *tetris) settle() {
+	for moved := true; moved; {
+		moved = false
+		for _, group := range t.area.groups() {
+			styles := make([]int, len(group))
+			for i, square := range group {
+				styles[i] = t.area[square[1]][square[0]]
+				t.area[square[1]][square[0]] = noStyle
+			}
+
+			fall := 0
+		FallLoop:
+			for {
+				for _, square := range group {
+					y := square[1] + fall + 1
+					if y >= len(t.area) || t.area[y][square[0]] != noStyle {
+						break FallLoop
+					}
+				}
+				fall++
+			}
+
+			for i, square := range group {
+				t.area[square[1]+fall][square[0]] = styles[i]
+			}
+			moved = moved || fall > 0
+		}
+	}
+}
+
+// with cascade gravity, look for lines completed by the fall of the
+// squares after a clear, and prepare their removal as the next step
+// of the chain, returns false if there are none
+func (t *tetris) nextChain() bool {
+	if !t.cascade {
+		return false
+	}
+	for y := len(t.area) - 1; y >= 0; y-- {
+		if t.area[y].complete() {
+			t.chain++
+			t.toCheck = [2]int{max(0, y-blockSize+1), y}
+			t.toRemoveNum, t.firstAvailable, t.toRemove = t.checkLines()
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Relay server for the online versus mode of the game
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/loig/ebitenginegamejam2024/relay"
+)
+
+func main() {
+	address := flag.String("listen", ":7777", "Address to listen on")
+	flag.Parse()
+
+	listener, err := net.Listen("tcp", *address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("relay listening on %s", listener.Addr())
+
+	log.Fatal(relay.New().Serve(listener))
+}
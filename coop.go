@@ -0,0 +1,360 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/loig/ebitenginegamejam2024/assets"
+)
+
+// steps of a co-op run
+const (
+	coopSetup int = iota
+	coopPlay
+	coopDraft
+	coopOver
+)
+
+const coopNumPlayers int = 2
+
+// possible widths of the shared play area, in squares
+var coopWidths []int = []int{16, 20}
+
+type coopPlayer struct {
+	play   tetris
+	inputs KeyboardInputs
+}
+
+// two players with their own blocks on one wide play area,
+// lines, score and maluses are shared
+type coop struct {
+	state      int
+	players    [coopNumPlayers]coopPlayer
+	widthID    int // index of the width of the play area in coopWidths
+	balance    balancing
+	fog        fog
+	effects    improvementEffects
+	numChoices int
+	level      int
+	goalLevel  int
+	money      int // coins available in the draft, there are none in co-op
+	won        bool
+	screen     *ebiten.Image // offscreen image for drawing the wide play area
+}
+
+func newCoop(numChoices, goalLevel int) (c coop) {
+	c.players[0].inputs = KeyboardInputs{kmap: versusLeftKeys}
+	c.players[1].inputs = KeyboardInputs{kmap: versusRightKeys}
+	c.numChoices = numChoices
+	c.goalLevel = goalLevel
+	return
+}
+
+// set up the shared play area, each player getting blocks on one half of it
+func (c *coop) start() {
+	c.effects = improvementEffects{life: -1, canHold: true}
+	c.balance = newBalance(c.numChoices, c.effects)
+	c.level = 0
+	c.won = false
+
+	width := coopWidths[c.widthID]
+	for i := range c.players {
+		p := &c.players[i]
+		p.play = tetris{width: width, timing: modeTiming(classicTimingName), cascade: cascadeGravity}
+		p.play.init(0, c.balance, 0, 0, c.effects, c.effects.life)
+		p.play.spawnX = (2*i+1)*width/(2*coopNumPlayers) - 2
+		p.play.currentBlock.setInitialPosition(p.play.spawnX)
+	}
+	// the lines of the area are shared, so are the lines removals
+	for i := 1; i < coopNumPlayers; i++ {
+		c.players[i].play.area = c.players[0].play.area
+	}
+	c.fog.reset(c.balance, c.effects.fogProtection)
+
+	c.state = coopPlay
+}
+
+// start the next level after the draft
+func (c *coop) nextLevel() {
+	c.level++
+	c.effects = c.balance.applyBoons(c.effects)
+	for i := range c.players {
+		p := &c.players[i]
+		p.play.init(c.level, c.balance, c.level, p.play.score, c.effects, p.play.currentLife)
+	}
+	c.players[0].play.removeBottomLines(c.balance.getBombLines())
+	c.fog.reset(c.balance, c.effects.fogProtection)
+	c.state = coopPlay
+}
+
+// total score of the players
+func (c coop) score() (score int) {
+	for _, p := range c.players {
+		score += p.play.score
+	}
+	return
+}
+
+// total number of lines cleared by the players in the current level
+func (c coop) numLines() (lines int) {
+	for _, p := range c.players {
+		lines += p.play.numLines
+	}
+	return
+}
+
+// update the co-op mode, returns true when going back to the modes screen
+func (c *coop) update() (quit bool, playSounds [assets.NumSounds]bool) {
+	assignGamepads([]*KeyboardInputs{&c.players[0].inputs, &c.players[1].inputs})
+	for i := range c.players {
+		c.players[i].inputs.update()
+	}
+
+	switch c.state {
+	case coopSetup:
+		for _, p := range c.players {
+			if p.inputs.undo {
+				playSounds[assets.SoundMenuNoID] = true
+				return true, playSounds
+			}
+			if p.inputs.menuLeft || p.inputs.menuRight {
+				c.widthID = (c.widthID + 1) % len(coopWidths)
+				playSounds[assets.SoundMenuMoveID] = true
+			}
+			if p.inputs.enter {
+				playSounds[assets.SoundMenuConfirmID] = true
+				c.start()
+				return
+			}
+		}
+	case coopPlay:
+		playSounds = c.updatePlay()
+	case coopDraft:
+		left, right, enter := false, false, false
+		for _, p := range c.players {
+			left = left || p.inputs.menuLeft
+			right = right || p.inputs.menuRight
+			enter = enter || p.inputs.enter
+		}
+		var finished bool
+		finished, playSounds = c.balance.updateWithInputs(left, right, enter, &c.money)
+		if finished {
+			c.nextLevel()
+		}
+	case coopOver:
+		for _, p := range c.players {
+			if p.inputs.undo {
+				playSounds[assets.SoundMenuNoID] = true
+				return true, playSounds
+			}
+			if p.inputs.enter {
+				playSounds[assets.SoundMenuConfirmID] = true
+				c.state = coopSetup
+			}
+		}
+	}
+
+	return
+}
+
+// play one frame for both players, a player waits while
+// the other one is removing lines or losing
+func (c *coop) updatePlay() (playSounds [assets.NumSounds]bool) {
+	for i := range c.players {
+		if c.frozenBy(i) {
+			continue
+		}
+
+		p := &c.players[i]
+		p.play.obstacles = p.play.obstacles[:0]
+		for j, other := range c.players {
+			if j != i && other.play.blockInPlay() {
+				p.play.obstacles = append(p.play.obstacles, other.play.currentBlock)
+			}
+		}
+
+		p.play.initialActions = p.inputs.initialActions()
+		sounds := p.play.update(
+			p.inputs.down,
+			p.inputs.left,
+			p.inputs.right,
+			p.inputs.up,
+			p.inputs.alt,
+			p.inputs.space,
+			p.inputs.half,
+			false,
+			c.level,
+		)
+		for sound, play := range sounds {
+			playSounds[sound] = playSounds[sound] || play
+		}
+
+		// lines removed may overlap the block of another player
+		for j := range c.players {
+			if j != i {
+				c.separate(j)
+			}
+		}
+	}
+	c.fog.update(c.players[0].play.currentBlock, c.players[1].play.currentBlock)
+
+	for _, p := range c.players {
+		if p.play.dead {
+			if !p.play.inAnimation {
+				c.state = coopOver
+			}
+			return
+		}
+	}
+
+	if !c.inAnimation() && c.numLines() >= c.balance.getGoalLines() {
+		if c.level+1 >= c.goalLevel {
+			c.won = true
+			c.state = coopOver
+			playSounds[assets.SoundBuyID] = true
+			return
+		}
+		c.state = coopDraft
+		c.balance.startDraft(c.level, c.money)
+	}
+
+	return
+}
+
+// check if a player must wait for another one
+func (c coop) frozenBy(player int) bool {
+	for i, p := range c.players {
+		if i != player && (p.play.dead || p.play.removeLineAnimationStep > 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c coop) inAnimation() bool {
+	for _, p := range c.players {
+		if p.play.inAnimation {
+			return true
+		}
+	}
+	return false
+}
+
+// move the block of a player up until it does not overlap the squares of the area
+func (c *coop) separate(player int) {
+	p := &c.players[player]
+	if !p.play.blockInPlay() || p.play.dead {
+		return
+	}
+	for p.play.currentBlock.y > 0 && !p.play.currentBlock.isInValidPosition(p.play.area) {
+		p.play.currentBlock.y--
+	}
+}
+
+func (c *coop) draw(screen *ebiten.Image) {
+	screen.Fill(gTextColor)
+
+	width := coopWidths[c.widthID]
+	if c.state != coopSetup {
+		width = c.players[0].play.area.width()
+	}
+
+	// info panel of the first player, play area, info panel of the second player
+	xGrid := gInfoPanelWidth + gPlayAreaSide
+	screenWidth := 2*gInfoPanelWidth + 2*gPlayAreaSide + width*gSquareSideSize
+	if c.screen == nil || c.screen.Bounds().Dx() != screenWidth {
+		c.screen = ebiten.NewImage(screenWidth, gHeight)
+	}
+	c.screen.Clear()
+
+	gray := uint8(255)
+	if c.state != coopPlay {
+		gray = 100
+	}
+
+	drawInfoBack(c.screen, 0, gPlayAreaHeightInBlocks, gray)
+	xInfo := drawPlayAreaBack(c.screen, gInfoPanelWidth, width, gPlayAreaHeightInBlocks, gray)
+	drawInfoBack(c.screen, xInfo, gPlayAreaHeightInBlocks, gray)
+
+	if c.state != coopSetup {
+		c.drawPlay(c.screen, gray, xGrid, [coopNumPlayers]int{0, xInfo})
+	}
+
+	scale := float64(gWidth) / float64(screenWidth)
+	yBoard := (float64(gHeight) - float64(gHeight)*scale) / 2
+	options := ebiten.DrawImageOptions{}
+	options.GeoM.Scale(scale, scale)
+	options.GeoM.Translate(0, yBoard)
+	screen.DrawImage(c.screen, &options)
+
+	drawTextCentered(screen, "CO-OP", gWidth/2, int(yBoard)/2, 1.5*gTextScale, gTextLightColor)
+
+	controls := "LEFT: WASD, Q/E ROTATE, R HALF TURN, TAB START, ESC QUIT\nRIGHT: ARROWS, ,/. ROTATE, / HALF TURN, ENTER START, BACKSPACE QUIT"
+	switch c.state {
+	case coopSetup:
+		drawTextBanner(screen, fmt.Sprintf("< WIDTH %d >", width), (gWidth-gTextMalusWidth)/2, gHeight/2-gTextMalusHeight/2, gTextMalusWidth, gTextMalusHeight)
+	case coopPlay:
+		controls = ""
+	case coopDraft:
+		c.balance.draw(screen)
+		controls = ""
+	case coopOver:
+		result := "GAME OVER"
+		if c.won {
+			result = "YOU WIN"
+		}
+		drawTextBanner(screen, fmt.Sprintf("%s\nSCORE %d", result, c.score()), (gWidth-gTextMalusWidth)/2, gHeight/2-gTextMalusHeight/2, gTextMalusWidth, gTextMalusHeight)
+		controls = "ENTER: AGAIN   ESC/BACKSPACE: QUIT"
+	}
+	drawTextCentered(screen, controls, gWidth/2, gHeight-int(yBoard)/2, gTextScale/1.5, gTextLightColor)
+}
+
+// draw the shared area, the blocks of the players and their info panels
+func (c coop) drawPlay(screen *ebiten.Image, gray uint8, xGrid int, xInfos [coopNumPlayers]int) {
+	width := c.players[0].play.area.width()
+	yOrigin := gSquareSideSize * -gInvisibleLines
+
+	drawDeathLines(screen, xGrid, width, c.players[0].play.deathLines, gray)
+
+	// the grid is drawn by the player removing lines, if any, for the animation
+	drawer := c.players[0].play
+	for _, p := range c.players {
+		if p.play.removeLineAnimationStep > 0 {
+			drawer = p.play
+		}
+	}
+	for _, p := range c.players {
+		if p.play.blockInPlay() && !p.play.dead {
+			p.play.drawCurrentBlock(screen, gray, xGrid, yOrigin)
+		}
+	}
+	drawer.drawGrid(screen, gray, xGrid, yOrigin)
+	c.fog.draw(screen, gray, xGrid, width, c.players[0].play.height, c.players[0].play.mirrored)
+
+	for i, p := range c.players {
+		p.play.drawInfo(screen, gray, xInfos[i])
+		xRight := xInfos[i] + gInfoPanelWidth
+		drawNumberAt(screen, gray, xRight-gXLinesFromRightSide+gMultFactor, gYLinesFromTop, c.numLines(), c.balance.getGoalLines())
+		drawNumberAt(screen, gray, xRight-gXScoreFromRightSide+gMultFactor, gYScoreFromTop, c.score(), -1)
+		drawNumberAt(screen, gray, xRight-gXLevelFromRightSide+gMultFactor, gYLevelFromTop, c.level+1, c.goalLevel)
+		drawTextCentered(screen, fmt.Sprintf("PLAYER %d", i+1), xRight-gInfoRightSide-gInfoWidth/2, gHeight-gSquareSideSize/2, gTextScale, scaleColor(gTextColor, gray))
+	}
+}
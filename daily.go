@@ -0,0 +1,294 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"github.com/loig/ebitenginegamejam2024/assets"
+)
+
+const (
+	dailyDateLayout  string = "2006-01-02" // format of the dates in the history
+	dailyHistoryFile string = "daily.json" // in the configuration directory of the game
+	dailyCellWidth   int    = 140          // size of the days in the calendar, in pixels
+	dailyCellHeight  int    = 70
+)
+
+// result of the scored attempt of one day
+type dailyResult struct {
+	Date  string `json:"date"`
+	Level int    `json:"level"` // number of levels completed
+	Score int    `json:"score"`
+	Won   bool   `json:"won"`
+}
+
+// the daily challenge: the results of the previous days and the current attempt
+type daily struct {
+	history    []dailyResult      // ordered by date
+	path       string             // file where the history is saved, nothing is saved if empty
+	playing    bool               // the current run is a daily challenge
+	scored     bool               // the current run is the first attempt of the day
+	date       string             // day of the current attempt
+	effects    improvementEffects // improvements given to everyone for the day
+	savedMoney int                // coins of the adventure, put aside during the challenge
+	last       *dailyResult       // result of the last attempt, scored or not
+	month      time.Time          // first day of the month displayed in the calendar
+}
+
+// seed of the blocks, maluses and improvements of a day
+func dailySeed(day time.Time) int64 {
+	return int64(day.Year()*10000 + int(day.Month())*100 + day.Day())
+}
+
+// improvements given for the daily challenge, a random level for each of them
+func dailyLoadout(rng *rand.Rand) improvements {
+	loadout := setupImprovements()
+	for pos, imp := range loadout.catalog {
+		loadout.levels[pos] = rng.Intn(len(imp.prices) + 1)
+	}
+	return loadout
+}
+
+// read the history of the daily challenge, it starts empty if it cannot be read
+func loadDaily() (d daily) {
+	d.month = firstOfMonth(time.Now())
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return
+	}
+	d.path = filepath.Join(dir, "yatc", dailyHistoryFile)
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &d.history); err != nil {
+		d.history = nil
+	}
+	return
+}
+
+// write the history of the daily challenge, errors are ignored: the
+// history is then only kept until the game is closed
+func (d daily) save() {
+	if d.path == "" {
+		return
+	}
+	data, err := json.Marshal(d.history)
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(d.path), 0o755) == nil {
+		os.WriteFile(d.path, data, 0o644)
+	}
+}
+
+// result of the scored attempt of a day, if it was played
+func (d daily) result(date string) (dailyResult, bool) {
+	pos := sort.Search(len(d.history), func(i int) bool { return d.history[i].Date >= date })
+	if pos < len(d.history) && d.history[pos].Date == date {
+		return d.history[pos], true
+	}
+	return dailyResult{}, false
+}
+
+// set the result of a day in the history and save it
+func (d *daily) record(result dailyResult) {
+	pos := sort.Search(len(d.history), func(i int) bool { return d.history[i].Date >= result.Date })
+	if pos < len(d.history) && d.history[pos].Date == result.Date {
+		d.history[pos] = result
+	} else {
+		d.history = append(d.history[:pos], append([]dailyResult{result}, d.history[pos:]...)...)
+	}
+	d.save()
+}
+
+// number of consecutive days played up to today (or yesterday if today
+// was not played yet) and longest run of consecutive days played
+func (d daily) streaks(today time.Time) (current, best int) {
+	var previous time.Time
+	run := 0
+	for _, result := range d.history {
+		day, err := time.ParseInLocation(dailyDateLayout, result.Date, time.Local)
+		if err != nil {
+			continue
+		}
+		if run > 0 && day.Equal(previous.AddDate(0, 0, 1)) {
+			run++
+		} else {
+			run = 1
+		}
+		previous = day
+		best = max(best, run)
+	}
+
+	today = firstOfDay(today)
+	if previous.Equal(today) || previous.Equal(today.AddDate(0, 0, -1)) {
+		current = run
+	}
+	return
+}
+
+func firstOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+func firstOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+}
+
+// start the daily challenge: the blocks, the maluses offered and the
+// improvements only depend on the date, only the first attempt of the
+// day is scored, the coins of the adventure are put aside meanwhile
+func (g *game) startDaily() {
+	today := time.Now()
+	rng := rand.New(rand.NewSource(dailySeed(today)))
+
+	g.daily.playing = true
+	g.daily.date = today.Format(dailyDateLayout)
+	_, played := g.daily.result(g.daily.date)
+	g.daily.scored = !played
+	if g.daily.scored {
+		g.daily.record(dailyResult{Date: g.daily.date})
+	}
+	g.daily.effects = dailyLoadout(rng).getEffects()
+	g.daily.savedMoney = g.money.money
+	g.money.money = 0
+
+	g.firstPlay = false
+	g.state = statePlay
+	g.level = 0
+	g.runGoalLevel = g.goalLevel
+	g.runCoinRate = 100
+	g.balance = newBalance(g.numChoices, g.daily.effects)
+	g.balance.rng = rand.New(rand.NewSource(rng.Int63()))
+	g.currentPlay.rng = rand.New(rand.NewSource(rng.Int63()))
+	g.currentPlay.width = gPlayAreaWidthInBlocks
+	g.currentPlay.height = gPlayAreaHeightInBlocks
+	g.currentPlay.pieceSet = nil
+	g.currentPlay.timing = getTiming(classicTimingName)
+	g.currentPlay.cascade = false
+	g.currentPlay.init(g.level, g.balance, g.level, 0, g.daily.effects, g.daily.effects.life)
+	g.fog.reset(g.balance, g.daily.effects.fogProtection)
+}
+
+// end of the daily challenge, the result is kept if the attempt is scored
+func (g *game) endDaily(won bool) {
+	result := dailyResult{Date: g.daily.date, Level: g.level, Score: g.currentPlay.score, Won: won}
+	if won {
+		result.Level = g.runGoalLevel
+	}
+	if g.daily.scored {
+		g.daily.record(result)
+	}
+	g.daily.last = &result
+	g.daily.playing = false
+	g.money.money = g.daily.savedMoney
+	g.currentPlay.rng = nil
+	g.daily.month = firstOfMonth(time.Now())
+	g.state = stateDaily
+}
+
+// calendar of the daily challenge, returns true when going back to the modes
+func (g *game) updateStateDaily() (back bool) {
+	if g.inputs.menuLeft {
+		g.audio.NextSounds[assets.SoundMenuMoveID] = true
+		g.daily.month = g.daily.month.AddDate(0, -1, 0)
+	}
+	if g.inputs.menuRight {
+		g.audio.NextSounds[assets.SoundMenuMoveID] = true
+		g.daily.month = g.daily.month.AddDate(0, 1, 0)
+	}
+	if g.inputs.undo {
+		g.audio.NextSounds[assets.SoundMenuNoID] = true
+		return true
+	}
+	if g.inputs.enter {
+		g.audio.NextSounds[assets.SoundMenuConfirmID] = true
+		g.startDaily()
+	}
+	return false
+}
+
+// calendar of one month, with the days played, won or not, and today's status
+func (g game) drawStateDaily(screen *ebiten.Image) {
+	screen.DrawImage(assets.ImageShopBack, &ebiten.DrawImageOptions{})
+
+	drawTextCentered(screen, "DAILY CHALLENGE", gWidth/2, 3*gTitleMargin+gTextCharHeight*int(gTextScale), 2*gTextScale, gTextColor)
+
+	now := time.Now()
+	today := now.Format(dailyDateLayout)
+	yMonth := 3*gTitleMargin + 4*gTextCharHeight*int(gTextScale)
+	drawTextCentered(screen, "< "+fmt.Sprintf("%s %d", g.daily.month.Month(), g.daily.month.Year())+" >", gWidth/2, yMonth, gTextScale, gTextColor)
+
+	// weeks start on monday
+	xStart := gWidth/2 - 7*dailyCellWidth/2
+	yStart := yMonth + gTextCharHeight*int(gTextScale)
+	for i, name := range []string{"MO", "TU", "WE", "TH", "FR", "SA", "SU"} {
+		drawTextCentered(screen, name, xStart+i*dailyCellWidth+dailyCellWidth/2, yStart+dailyCellHeight/2, gTextScale/1.5, gTextColor)
+	}
+	offset := (int(g.daily.month.Weekday()) + 6) % 7
+	for day := g.daily.month; day.Month() == g.daily.month.Month(); day = day.AddDate(0, 0, 1) {
+		cell := offset + day.Day() - 1
+		x := float32(xStart + (cell%7)*dailyCellWidth)
+		y := float32(yStart + (cell/7+1)*dailyCellHeight)
+		date := day.Format(dailyDateLayout)
+		if result, played := g.daily.result(date); played {
+			color := gMalusColor
+			if result.Won {
+				color = gBoonColor
+			}
+			vector.DrawFilledRect(screen, x+4, y+4, float32(dailyCellWidth-8), float32(dailyCellHeight-8), color, false)
+		}
+		if date == today {
+			vector.StrokeRect(screen, x+4, y+4, float32(dailyCellWidth-8), float32(dailyCellHeight-8), 4, gTextColor, false)
+		}
+		drawTextCentered(screen, fmt.Sprint(day.Day()), int(x)+dailyCellWidth/2, int(y)+dailyCellHeight/2, gTextScale/1.5, gTextColor)
+	}
+
+	status := "TODAY: NOT PLAYED YET"
+	if result, played := g.daily.result(today); played {
+		status = fmt.Sprintf("TODAY: LEVEL %d, SCORE %d", result.Level, result.Score)
+		if result.Won {
+			status = fmt.Sprintf("TODAY: WON, SCORE %d", result.Score)
+		}
+	}
+	if g.daily.last != nil && g.daily.last.Date == today {
+		if result, _ := g.daily.result(today); *g.daily.last != result {
+			status += fmt.Sprintf("\nPRACTICE: LEVEL %d, SCORE %d", g.daily.last.Level, g.daily.last.Score)
+		}
+	}
+	current, best := g.daily.streaks(now)
+	status += fmt.Sprintf("\nSTREAK %d   BEST %d", current, best)
+	drawTextCentered(screen, status, gWidth/2, gHeight-9*gTitleMargin, gTextScale, gTextColor)
+
+	controls := "ENTER: PLAY   LEFT/RIGHT: MONTH   BACKSPACE: BACK"
+	if _, played := g.daily.result(today); played {
+		controls = "ENTER: PRACTICE   LEFT/RIGHT: MONTH   BACKSPACE: BACK"
+	}
+	drawTextCentered(screen, controls, gWidth/2, gHeight-5*gTitleMargin, gTextScale/1.5, gTextColor)
+}
@@ -19,6 +19,8 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"fmt"
+	"image"
 	"image/color"
 	"math"
 
@@ -39,17 +41,38 @@ func (g *game) Draw(screen *ebiten.Image) {
 		} else {
 			screen.DrawImage(assets.ImageTitle2, &ebiten.DrawImageOptions{})
 		}
-		if g.titleSelect == 0 {
+		dailyWidth, _ := textSize("DAILY CHALLENGE", gTextScale/1.5)
+		drawTextCentered(screen, "DAILY CHALLENGE", gWidth/2, 3*gHeight/4+215, gTextScale/1.5, gTextColor)
+		if g.ascension.unlocked > 0 {
+			drawTextCentered(screen, fmt.Sprintf("< ASCENSION %d >", g.ascension.selected), gWidth/2+380, 3*gHeight/4+46, gTextScale/1.5, gTextColor)
+		}
+		switch g.titleSelect {
+		case titlePlay:
 			drawArrow(screen, gWidth/2-150, 3*gHeight/4+20, math.Pi/2, g.titleFrame)
-		} else {
+		case titleCredits:
 			drawArrow(screen, gWidth/2-250, 3*gHeight/4+128, math.Pi/2, g.titleFrame)
+		case titleDaily:
+			drawArrow(screen, gWidth/2-dailyWidth/2-20, 3*gHeight/4+215-gArrowWidth/2, math.Pi/2, g.titleFrame)
 		}
+	case stateModes:
+		g.drawStateModes(screen)
+	case stateDaily:
+		g.drawStateDaily(screen)
+	case stateVersus:
+		g.versus.draw(screen)
+	case stateCoop:
+		g.coop.draw(screen)
+	case stateOnline:
+		g.online.draw(screen)
 	case statePlay:
 		g.drawPlay(screen, 255)
+	case stateDemo:
+		g.drawPlay(screen, 255)
+		drawTextCentered(screen, "DEMO\nPRESS ANY KEY", gPlayAreaSide+gPlayAreaWidth/2, 2*gSquareSideSize, gTextScale, gTextColor)
 	case stateBalance:
 		g.drawPlay(screen, 100)
 		g.balance.draw(screen)
-	case stateLost:
+	case stateRunEnd:
 		g.drawPlay(screen, 100)
 		g.money.draw(screen)
 	case stateImprove:
@@ -61,6 +84,11 @@ func (g *game) Draw(screen *ebiten.Image) {
 		options.GeoM.Translate(float64(gWidth/2)-175, float64(gHeight/2)-140)
 		options.GeoM.Translate(0, -float64(gAnimRocket[g.winFrame%len(gAnimRocket)]))
 		screen.DrawImage(assets.ImageRocket, &options)
+		won := "ENTER: CONTINUE"
+		if g.newTier {
+			won = fmt.Sprintf("ASCENSION %d UNLOCKED\n%s\n\n%s", g.ascension.unlocked, ascensionTiers[g.ascension.unlocked-1].name, won)
+		}
+		drawTextCentered(screen, won, gWidth/2, gHeight-150, gTextScale/1.5, gTextColor)
 	}
 
 }
@@ -75,37 +103,121 @@ func (g game) drawShop(screen *ebiten.Image) {
 
 }
 
-func (g game) drawPlay(screen *ebiten.Image, gray uint8) {
-	// draw background
-	options := ebiten.DrawImageOptions{}
-	options.ColorScale.ScaleWithColor(color.Gray{gray})
-	screen.DrawImage(assets.ImageBack, &options)
-	// draw death lines
-	g.drawDeathLines(screen, gray)
+func (g *game) drawPlay(screen *ebiten.Image, gray uint8) {
+	width, height := playAreaImageSize(g.currentPlay.area.width(), g.currentPlay.height)
+	if g.playScreen == nil || g.playScreen.Bounds().Dx() != width || g.playScreen.Bounds().Dy() != height {
+		g.playScreen = ebiten.NewImage(width, height)
+	}
+	g.playScreen.Clear()
 
-	// draw current play
-	g.currentPlay.draw(screen, gray)
+	drawPlayArea(g.playScreen, g.currentPlay, g.fog, gray)
 	// draw number of lines destroyed
-	drawNumberAt(screen, gray, gWidth-gXLinesFromRightSide+gMultFactor, gYLinesFromTop, g.currentPlay.numLines, g.balance.getGoalLines())
+	drawNumberAt(g.playScreen, gray, width-gXLinesFromRightSide+gMultFactor, gYLinesFromTop, g.currentPlay.numLines, g.balance.getGoalLines())
 	// draw score
-	drawNumberAt(screen, gray, gWidth-gXScoreFromRightSide+gMultFactor, gYScoreFromTop, g.currentPlay.score, -1)
+	drawNumberAt(g.playScreen, gray, width-gXScoreFromRightSide+gMultFactor, gYScoreFromTop, g.currentPlay.score, -1)
 	// draw level
-	drawNumberAt(screen, gray, gWidth-gXLevelFromRightSide+gMultFactor, gYLevelFromTop, g.level+1, g.goalLevel)
+	drawNumberAt(g.playScreen, gray, width-gXLevelFromRightSide+gMultFactor, gYLevelFromTop, g.level+1, g.runGoalLevel)
+	// draw coin multiplier
+	drawTextCentered(g.playScreen, "COINS "+formatMultiplier(g.balance.getMultiplier()), width-gInfoRightSide-gInfoWidth/2, gHeight-gSquareSideSize/2, gTextScale, scaleColor(gTextColor, gray))
+
+	drawFitted(screen, g.playScreen)
+}
+
+// size in pixels of the image holding a play area of a given size in squares and its info panel
+func playAreaImageSize(width, height int) (imageWidth, imageHeight int) {
+	return 2*gPlayAreaSide + width*gSquareSideSize + gInfoPanelWidth, max(gHeight, height*gSquareSideSize)
+}
+
+// draw an image at the center of the screen, scaled down if it does not fit
+func drawFitted(screen, img *ebiten.Image) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	scale := min(1, float64(gWidth)/float64(width), float64(gHeight)/float64(height))
+	if float64(width)*scale < float64(gWidth) || float64(height)*scale < float64(gHeight) {
+		screen.Fill(gTextColor)
+	}
+
+	options := ebiten.DrawImageOptions{}
+	options.GeoM.Scale(scale, scale)
+	options.GeoM.Translate((float64(gWidth)-float64(width)*scale)/2, (float64(gHeight)-float64(height)*scale)/2)
+	screen.DrawImage(img, &options)
+}
+
+// draw the background, a tetris game and its fog
+func drawPlayArea(screen *ebiten.Image, t tetris, f fog, gray uint8) {
+	width := t.area.width()
+	xInfo := drawPlayAreaBack(screen, 0, width, t.height, gray)
+	drawInfoBack(screen, xInfo, t.height, gray)
+	// draw death lines
+	drawDeathLines(screen, gPlayAreaSide, width, t.deathLines, gray)
+	drawShields(screen, gPlayAreaSide, width, t.shields, gray)
+
+	// draw current play
+	t.draw(screen, gray)
 	// hide lines
-	g.fog.draw(screen, gray)
+	f.draw(screen, gray, gPlayAreaSide, width, t.height, t.mirrored)
+}
+
+// draw the borders and the inside of a play area of a given size in squares,
+// from a given x in pixels, returns the x at the right of the right border
+func drawPlayAreaBack(screen *ebiten.Image, x, width, height int, gray uint8) (xEnd int) {
+	border := assets.ImageBack.SubImage(image.Rect(0, 0, gPlayAreaSide, gHeight)).(*ebiten.Image)
+	inside := assets.ImageBack.SubImage(image.Rect(gPlayAreaSide, 0, gPlayAreaSide+gSquareSideSize, gHeight)).(*ebiten.Image)
+	rightBorder := assets.ImageBack.SubImage(image.Rect(gPlayAreaSide+gPlayAreaWidth, 0, 2*gPlayAreaSide+gPlayAreaWidth, gHeight)).(*ebiten.Image)
+
+	// the background is repeated downward for areas higher than the screen
+	for y := 0; y < height*gSquareSideSize; y += gHeight {
+		options := ebiten.DrawImageOptions{}
+		options.ColorScale.ScaleWithColor(color.Gray{gray})
+		options.GeoM.Translate(float64(x), float64(y))
+
+		screen.DrawImage(border, &options)
+		options.GeoM.Translate(float64(gPlayAreaSide), 0)
+		for column := 0; column < width; column++ {
+			screen.DrawImage(inside, &options)
+			options.GeoM.Translate(float64(gSquareSideSize), 0)
+		}
+		screen.DrawImage(rightBorder, &options)
+	}
+
+	return x + 2*gPlayAreaSide + width*gSquareSideSize
+}
+
+// draw the empty info panel (score, level, lines and next boxes) from a given x in pixels,
+// below it the background is extended down to the bottom of a play area of a given height in squares
+func drawInfoBack(screen *ebiten.Image, x, height int, gray uint8) {
+	options := ebiten.DrawImageOptions{}
+	options.ColorScale.ScaleWithColor(color.Gray{gray})
+	options.GeoM.Translate(float64(x), 0)
+	screen.DrawImage(assets.ImageBack.SubImage(image.Rect(gWidth-gInfoPanelWidth, 0, gWidth, gHeight)).(*ebiten.Image), &options)
+
+	if extra := height*gSquareSideSize - gHeight; extra > 0 {
+		options = ebiten.DrawImageOptions{}
+		options.ColorScale.ScaleWithColor(color.Gray{gray})
+		options.GeoM.Scale(1, float64(extra)/float64(gMultFactor))
+		options.GeoM.Translate(float64(x), float64(gHeight))
+		screen.DrawImage(assets.ImageBack.SubImage(image.Rect(gWidth-gInfoPanelWidth, gHeight-gMultFactor, gWidth, gHeight)).(*ebiten.Image), &options)
+	}
+}
+
+// xOrigin is the left side of the grid in pixels, width is given in squares
+func drawShields(screen *ebiten.Image, xOrigin, width, shields int, gray uint8) {
+	if shields > 0 {
+		drawTextCentered(screen, fmt.Sprintf("SHIELD X%d", shields), xOrigin+width*gSquareSideSize/2, gSquareSideSize/2, gTextScale, scaleColor(gTextLightColor, gray))
+	}
 }
 
-func (g game) drawDeathLines(screen *ebiten.Image, gray uint8) {
+// xOrigin is the left side of the grid in pixels, width is given in squares
+func drawDeathLines(screen *ebiten.Image, xOrigin, width, deathLines int, gray uint8) {
 	// death lines
 	options := ebiten.DrawImageOptions{}
 
 	options.ColorScale.ScaleWithColor(color.Gray{gray})
 	scaling := float64(gSquareSideSize) / float64(gDangerSide)
 	options.GeoM.Scale(scaling, scaling)
-	options.GeoM.Translate(float64(gPlayAreaSide), 0)
+	options.GeoM.Translate(float64(xOrigin), 0)
 	mult := 1
-	for line := 0; line < g.currentPlay.deathLines; line++ {
-		for pos := 0; pos < gPlayAreaWidthInBlocks; pos++ {
+	for line := 0; line < deathLines; line++ {
+		for pos := 0; pos < width; pos++ {
 			screen.DrawImage(assets.ImageDanger, &options)
 			options.GeoM.Translate(float64(mult*gSquareSideSize), 0)
 		}
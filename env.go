@@ -0,0 +1,391 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+)
+
+// actions available at each step of the environment,
+// rotations, hold and undo are applied only on the first frame of a step
+// as they need a key press in the game
+const (
+	envActionNone int = iota
+	envActionLeft
+	envActionRight
+	envActionDown
+	envActionRotateLeft
+	envActionRotateRight
+	envActionHold
+	envActionUndo
+	envActionRotateHalf
+	numEnvActions
+)
+
+var envActionNames [numEnvActions]string = [numEnvActions]string{
+	envActionNone:        "none",
+	envActionLeft:        "left",
+	envActionRight:       "right",
+	envActionDown:        "down",
+	envActionRotateLeft:  "rotate_left",
+	envActionRotateRight: "rotate_right",
+	envActionHold:        "hold",
+	envActionUndo:        "undo",
+	envActionRotateHalf:  "rotate_half",
+}
+
+// value of the cells of the board hidden by the fog in observations
+const envHiddenCell int = -1
+
+// one request of the protocol: a JSON object on one line
+type envRequest struct {
+	Cmd          string `json:"cmd"` // spec, reset or step
+	Seed         *int64 `json:"seed"`
+	Level        int    `json:"level"`
+	Maluses      []int  `json:"maluses"`      // level of each malus, in balancing order
+	Improvements []int  `json:"improvements"` // level of each improvement, in shop order
+	Width        int    `json:"width"`        // width of the board, the classic one if not given
+	Height       int    `json:"height"`       // visible lines of the board, the classic one if not given
+	PieceSet     string `json:"piece_set"`    // name of the piece set, the tetrominoes if not given
+	Gravity      string `json:"gravity"`      // name of the gravity curve, the classic one if not given
+	Timing       string `json:"timing"`       // name of the line clear and spawn delays, the classic ones if not given
+	Cascade      bool   `json:"cascade"`      // cascade gravity after line clears
+	Fog          bool   `json:"fog"`          // apply the fog maluses to observations
+	Invisible    bool   `json:"invisible"`    // apply the invisible blocks malus to observations
+	Action       int    `json:"action"`
+	Frames       int    `json:"frames"` // number of frames the action lasts, 1 if not given
+}
+
+// description of the environment, answer to the spec request
+type envSpec struct {
+	Actions        []string `json:"actions"`
+	Maluses        int      `json:"maluses"`
+	Improvements   int      `json:"improvements"`
+	Width          int      `json:"width"`
+	Height         int      `json:"height"`    // default board size, including the invisible lines
+	MaxWidth       int      `json:"max_width"` // limits of the size given in reset
+	MaxHeight      int      `json:"max_height"`
+	InvisibleLines int      `json:"invisible_lines"`
+	HiddenCell     int      `json:"hidden_cell"`
+}
+
+// a block as seen in observations, cells are absolute positions in the board
+type envBlock struct {
+	ID       int      `json:"id"`
+	X        int      `json:"x"`
+	Y        int      `json:"y"`
+	Rotation int      `json:"rotation"`
+	Cells    [][2]int `json:"cells"`
+}
+
+// answer to reset and step requests
+type envObservation struct {
+	Board     [][]int   `json:"board"`
+	Current   *envBlock `json:"current"` // nil when not visible
+	Queue     []int     `json:"queue"`
+	Hold      int       `json:"hold"`
+	CanHold   bool      `json:"can_hold"`
+	Score     int       `json:"score"`
+	Lines     int       `json:"lines"`
+	GoalLines int       `json:"goal_lines"`
+	Pieces    int       `json:"pieces"`
+	Frame     int       `json:"frame"`
+	Reward    int       `json:"reward"`
+	Done      bool      `json:"done"`
+	Won       bool      `json:"won"`
+}
+
+// answer to a request that could not be handled
+type envError struct {
+	Error string `json:"error"`
+}
+
+// one level of the game, played through requests
+type environment struct {
+	play          tetris
+	balance       balancing
+	fog           fog
+	level         int
+	frame         int
+	maskFog       bool
+	maskInvisible bool
+	started       bool
+}
+
+func (e *environment) reset(request envRequest) error {
+	if len(request.Maluses) > numBalances {
+		return fmt.Errorf("%d malus levels given, only %d maluses exist", len(request.Maluses), numBalances)
+	}
+
+	improv := setupImprovements()
+	if len(request.Improvements) > len(improv.levels) {
+		return fmt.Errorf("%d improvement levels given, only %d improvements exist", len(request.Improvements), len(improv.levels))
+	}
+	for i, level := range request.Improvements {
+		improv.levels[i] = max(0, min(level, len(improv.catalog[i].prices)))
+	}
+	effects := improv.getEffects()
+
+	width, height := gPlayAreaWidthInBlocks, gPlayAreaHeightInBlocks
+	if request.Width != 0 {
+		width = request.Width
+	}
+	if request.Height != 0 {
+		height = request.Height
+	}
+	if err := checkBoardSize(width, height); err != nil {
+		return err
+	}
+	var set *pieceSet
+	if request.PieceSet != "" {
+		if set = getPieceSet(strings.ToUpper(request.PieceSet)); set == nil {
+			return fmt.Errorf("unknown piece set %q", request.PieceSet)
+		}
+		if err := set.checkWidth(width); err != nil {
+			return err
+		}
+	}
+
+	curve := getGravityCurve(classicGravityName)
+	if request.Gravity != "" {
+		if curve = getGravityCurve(strings.ToUpper(request.Gravity)); curve == nil {
+			return fmt.Errorf("unknown gravity curve %q", request.Gravity)
+		}
+	}
+
+	delays := getTiming(classicTimingName)
+	if request.Timing != "" {
+		if delays = getTiming(strings.ToUpper(request.Timing)); delays == nil {
+			return fmt.Errorf("unknown timing %q", request.Timing)
+		}
+	}
+
+	seed := rand.Int63()
+	if request.Seed != nil {
+		seed = *request.Seed
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	e.balance = newBalance(0, effects)
+	e.balance.rng = rand.New(rand.NewSource(rng.Int63()))
+	for malus, level := range request.Maluses {
+		e.balance.levels[malus] = max(0, min(level, e.balance.maxLevels[malus]))
+	}
+
+	e.level = max(0, request.Level)
+	e.play = tetris{rng: rand.New(rand.NewSource(rng.Int63())), width: width, height: height, pieceSet: set, gravityCurve: curve, timing: delays, cascade: request.Cascade}
+	e.play.init(0, e.balance, e.level, 0, effects, effects.life)
+	e.fog.reset(e.balance, effects.fogProtection)
+	e.fog.follow(e.play.currentBlock)
+	e.frame = 0
+	e.maskFog = request.Fog
+	e.maskInvisible = request.Invisible
+	e.started = true
+
+	return nil
+}
+
+func (e *environment) step(action, frames int) (reward int, err error) {
+	if !e.started || e.isDone() {
+		return 0, errors.New("no level in progress, reset first")
+	}
+	if action < 0 || action >= numEnvActions {
+		return 0, fmt.Errorf("unknown action %d", action)
+	}
+
+	previousScore := e.play.score
+	for frame := 0; frame < max(frames, 1) && !e.isDone(); frame++ {
+		first := frame == 0
+		e.play.update(
+			action == envActionDown,
+			action == envActionLeft,
+			action == envActionRight,
+			first && action == envActionHold,
+			first && action == envActionRotateLeft,
+			first && action == envActionRotateRight,
+			first && action == envActionRotateHalf,
+			first && action == envActionUndo,
+			e.level,
+		)
+		e.fog.update(e.play.currentBlock)
+		e.frame++
+	}
+
+	return e.play.score - previousScore, nil
+}
+
+// the level is over when the game is lost or when the goal is reached
+func (e environment) isDone() bool {
+	return e.play.dead || e.isWon()
+}
+
+func (e environment) isWon() bool {
+	return !e.play.dead && !e.play.inAnimation && e.play.numLines >= e.balance.getGoalLines()
+}
+
+func (e environment) isHidden(x, y int) bool {
+	return e.maskFog && e.fog.hidesSquare(x, y, e.play.area.width(), e.play.height)
+}
+
+// build the observation of the current state, as seen by a player
+func (e environment) observe(reward int) (obs envObservation) {
+	obs.Board = make([][]int, len(e.play.area))
+	for y, line := range e.play.area {
+		obs.Board[y] = make([]int, len(line))
+		for x, style := range line {
+			if e.isHidden(x, y) {
+				style = envHiddenCell
+			}
+			obs.Board[y][x] = style
+		}
+	}
+
+	block := e.play.currentBlock
+	if !e.play.dead && !e.play.inAnimation && !(e.maskInvisible && e.play.currentBlockHidden()) {
+		obs.Current = &envBlock{ID: int(block.id), X: block.x, Y: block.y, Rotation: block.r}
+		for yRel, line := range block.states[block.r] {
+			for xRel, square := range line {
+				if square && !e.isHidden(block.x+xRel, block.y+yRel) {
+					obs.Current.Cells = append(obs.Current.Cells, [2]int{block.x + xRel, block.y + yRel})
+				}
+			}
+		}
+	}
+
+	obs.Queue = append(obs.Queue, int(e.play.nextBlock.id))
+	for _, preview := range e.play.previews {
+		obs.Queue = append(obs.Queue, int(preview.id))
+	}
+	obs.Hold = int(e.play.heldBlock.id)
+	obs.CanHold = e.play.holdAvailable()
+	obs.Score = e.play.score
+	obs.Lines = e.play.numLines
+	obs.GoalLines = e.balance.getGoalLines()
+	obs.Pieces = e.play.pieces
+	obs.Frame = e.frame
+	obs.Reward = reward
+	obs.Done = e.isDone()
+	obs.Won = e.isWon()
+
+	return
+}
+
+// answer one request
+func (e *environment) handle(request envRequest) any {
+	switch request.Cmd {
+	case "spec":
+		spec := envSpec{
+			Maluses:        numBalances,
+			Improvements:   len(setupImprovements().catalog),
+			Width:          gPlayAreaWidthInBlocks,
+			Height:         gPlayAreaHeightInBlocks + gInvisibleLines,
+			MaxWidth:       boardMaxWidth,
+			MaxHeight:      boardMaxHeight + gInvisibleLines,
+			InvisibleLines: gInvisibleLines,
+			HiddenCell:     envHiddenCell,
+		}
+		spec.Actions = envActionNames[:]
+		return spec
+	case "reset":
+		if err := e.reset(request); err != nil {
+			return envError{err.Error()}
+		}
+		return e.observe(0)
+	case "step":
+		reward, err := e.step(request.Action, request.Frames)
+		if err != nil {
+			return envError{err.Error()}
+		}
+		return e.observe(reward)
+	}
+	return envError{fmt.Sprintf("unknown command %q", request.Cmd)}
+}
+
+// serve one environment, reading requests from r and writing answers to w
+func serveEnvironment(r io.Reader, w io.Writer) error {
+	e := environment{}
+	scanner := bufio.NewScanner(r)
+	encoder := json.NewEncoder(w)
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var answer any
+		request := envRequest{}
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			answer = envError{err.Error()}
+		} else {
+			answer = e.handle(request)
+		}
+		if err := encoder.Encode(answer); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// run the env subcommand with the given command line arguments
+func runEnvironment(args []string) error {
+	flags := flag.NewFlagSet("env", flag.ContinueOnError)
+	var network, address string
+	flags.StringVar(&network, "network", "tcp", "Network to listen on: tcp or unix")
+	flags.StringVar(&address, "listen", "", "Address to listen on, one environment per connection (stdin/stdout if empty)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if address == "" {
+		return serveEnvironment(os.Stdin, os.Stdout)
+	}
+
+	if network != "tcp" && network != "unix" {
+		return fmt.Errorf("unknown network %q", network)
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	fmt.Fprintf(os.Stderr, "environment listening on %s %s\n", network, listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := serveEnvironment(conn, conn); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
+}
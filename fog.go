@@ -19,6 +19,7 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"image"
 	"image/color"
 
 	"github.com/hajimehoshi/ebiten/v2"
@@ -29,65 +30,221 @@ const (
 	fogFramesPerLine  int = 60
 	fogHoldFrames     int = 20
 	fogDecreaseFactor int = 4
+
+	fogTopRecede          int = 2 // lines uncovered by the top fog for each level of protection
+	fogCurtainRecede      int = 1 // columns uncovered on each side by the curtain for each level of protection
+	fogSpotlightRecede    int = 1 // squares added to the spotlight radius for each level of protection
+	fogSpotlightMaxRadius int = 6 // radius in squares of the spotlight when its malus is at level 0
 )
 
+// one kind of fog, covering an amount of lines or columns, and receding
+// regularly when the player is protected by the hide move improvement
+type fogLayer struct {
+	amount          int // amount covered without protection
+	current         int
+	recede          int // amount uncovered for each level of protection
+	protectionLevel int
+	frame           int
+	decreasing      bool
+}
+
+// the fog maluses: lines hidden from the bottom and from the top, columns
+// hidden from both sides as a curtain, and darkness outside of a spotlight
 type fog struct {
-	hiddenLines        int
-	currentHiddenLines int
-	protectionLevel    int
-	frame              int
-	decreasing         bool
-}
-
-func (f *fog) reset(hiddenLines int, protectionLevel int) {
-	f.hiddenLines = hiddenLines
-	f.currentHiddenLines = hiddenLines
-	f.protectionLevel = protectionLevel
-	f.frame = 0
-	f.decreasing = false
-}
-
-func (f *fog) update() {
-	if f.protectionLevel > 0 {
-		f.frame++
-		if !f.decreasing && f.currentHiddenLines >= f.hiddenLines {
-			if f.frame >= fogHoldFrames {
-				f.frame = 0
-				f.decreasing = true
+	bottom    fogLayer
+	top       fogLayer
+	curtain   fogLayer
+	spotlight fogLayer     // covers the squares between the spotlight and its largest radius
+	spots     [][2]float64 // centers of the spotlight, one per block in play, in squares
+}
+
+func (l *fogLayer) reset(amount, recede, protectionLevel int) {
+	l.amount = amount
+	l.current = amount
+	l.recede = recede
+	l.protectionLevel = protectionLevel
+	l.frame = 0
+	l.decreasing = false
+}
+
+func (l *fogLayer) update() {
+	if l.protectionLevel > 0 {
+		l.frame++
+		if !l.decreasing && l.current >= l.amount {
+			if l.frame >= fogHoldFrames {
+				l.frame = 0
+				l.decreasing = true
 			}
 			return
 		}
-		if f.frame >= fogFramesPerLine {
-			f.frame = 0
-			if f.decreasing {
-				f.currentHiddenLines--
-				if f.hiddenLines-fogDecreaseFactor*f.protectionLevel >= f.currentHiddenLines {
-					f.decreasing = false
+		if l.frame >= fogFramesPerLine {
+			l.frame = 0
+			if l.decreasing {
+				l.current--
+				if l.amount-l.recede*l.protectionLevel >= l.current {
+					l.decreasing = false
 				}
 			} else {
-				f.currentHiddenLines++
+				l.current++
 			}
 		}
 	}
 }
 
-func (f fog) draw(screen *ebiten.Image, gray uint8) {
+// amount covered, in lines or columns, including the progress of the current move
+func (l fogLayer) position() float64 {
+	position := float64(l.current)
+	if (l.decreasing && l.current > 0) || (!l.decreasing && l.current < l.amount) {
+		move := float64(l.frame) / float64(fogFramesPerLine)
+		if l.decreasing {
+			position -= move
+		} else {
+			position += move
+		}
+	}
+	return position
+}
 
-	y := float64(gPlayAreaHeight - f.currentHiddenLines*gSquareSideSize)
+func (f *fog) reset(b balancing, protectionLevel int) {
+	f.bottom.reset(b.getHiddenLines(), fogDecreaseFactor, protectionLevel)
+	f.top.reset(b.getTopFogLines(), fogTopRecede, protectionLevel)
+	f.curtain.reset(b.getCurtainColumns(), fogCurtainRecede, protectionLevel)
+	f.spotlight.reset(b.getSpotlightDarkness(), fogSpotlightRecede, protectionLevel)
+	f.spots = f.spots[:0]
+}
 
-	if (f.decreasing && f.currentHiddenLines > 0) || (!f.decreasing && f.currentHiddenLines < f.hiddenLines) {
-		yDec := (float64(f.frame) / float64(fogFramesPerLine)) * float64(gSquareSideSize)
-		if f.decreasing {
-			y += yDec
-		} else {
-			y -= yDec
+// move the fog, the spotlight follows the given blocks
+func (f *fog) update(blocks ...tetrisBlock) {
+	f.bottom.update()
+	f.top.update()
+	f.curtain.update()
+	f.spotlight.update()
+	f.follow(blocks...)
+}
+
+// put the spotlight on the given blocks
+func (f *fog) follow(blocks ...tetrisBlock) {
+	f.spots = f.spots[:0]
+	for _, block := range blocks {
+		if block.id >= 0 {
+			f.spots = append(f.spots, block.center())
 		}
 	}
+}
+
+// check if a line of the grid (including invisible lines) is currently under the fog,
+// height is the number of visible lines of the grid
+func (f fog) hidesLine(y, height int) bool {
+	return y >= height+gInvisibleLines-f.bottom.current ||
+		(y >= gInvisibleLines && y < gInvisibleLines+min(f.top.current, height/2))
+}
+
+// check if a square of the grid (including invisible lines) is currently hidden
+// by the fog, width and height are the size of the visible part of the grid
+func (f fog) hidesSquare(x, y, width, height int) bool {
+	if f.hidesLine(y, height) {
+		return true
+	}
+	if side := min(f.curtain.current, (width-2)/2); x < side || x >= width-side {
+		return true
+	}
+	return f.spotlight.amount > 0 && !f.inSpotlight(float64(x)+0.5, float64(y)+0.5, float64(fogSpotlightMaxRadius-f.spotlight.current))
+}
+
+// check if a point of the grid, in squares, is close enough to a block in play
+func (f fog) inSpotlight(x, y, radius float64) bool {
+	if len(f.spots) == 0 {
+		return true
+	}
+	for _, spot := range f.spots {
+		if (x-spot[0])*(x-spot[0])+(y-spot[1])*(y-spot[1]) <= radius*radius {
+			return true
+		}
+	}
+	return false
+}
+
+// xOrigin is the left side of the grid in pixels, width and height are given in squares,
+// mirrored is set when the grid is drawn mirrored
+func (f fog) draw(screen *ebiten.Image, gray uint8, xOrigin, width, height int, mirrored bool) {
+	f.drawBottom(screen, gray, xOrigin, width, height)
+	f.drawTop(screen, gray, xOrigin, width, height)
+	f.drawCurtain(screen, gray, xOrigin, width, height)
+	f.drawSpotlight(screen, gray, xOrigin, width, height, mirrored)
+}
+
+func (f fog) drawBottom(screen *ebiten.Image, gray uint8, xOrigin, width, height int) {
+
+	y := (float64(height) - f.bottom.position()) * float64(gSquareSideSize)
+
+	if y > 0 {
+		options := ebiten.DrawImageOptions{}
+		options.GeoM.Scale(float64(width)/float64(gPlayAreaWidthInBlocks), 1)
+		options.GeoM.Translate(float64(xOrigin), y)
+		options.ColorScale.ScaleWithColor(color.Gray{gray})
+		screen.DrawImage(assets.ImageFog, &options)
+	}
+}
+
+// the fog image upside down, going down from the top of the play area
+func (f fog) drawTop(screen *ebiten.Image, gray uint8, xOrigin, width, height int) {
+
+	y := min(f.top.position(), float64(height/2)) * float64(gSquareSideSize)
 
 	if y > 0 {
 		options := ebiten.DrawImageOptions{}
-		options.GeoM.Translate(float64(gPlayAreaSide), y)
+		options.GeoM.Scale(float64(width)/float64(gPlayAreaWidthInBlocks), -1)
+		options.GeoM.Translate(float64(xOrigin), y)
 		options.ColorScale.ScaleWithColor(color.Gray{gray})
 		screen.DrawImage(assets.ImageFog, &options)
 	}
 }
+
+// columns of fog closing from both sides
+func (f fog) drawCurtain(screen *ebiten.Image, gray uint8, xOrigin, width, height int) {
+
+	side := min(f.curtain.position(), float64((width-2)/2)) * float64(gSquareSideSize)
+
+	if side > 0 {
+		xRight := float64(xOrigin+width*gSquareSideSize) - side
+		for y := 0; y < height; y++ {
+			drawFogTexture(screen, float64(xOrigin), float64(y*gSquareSideSize), side, 0, y, gray)
+			drawFogTexture(screen, xRight, float64(y*gSquareSideSize), side, float64(gPlayAreaWidth)-side, y, gray)
+		}
+	}
+}
+
+// darkness on the squares far from the blocks in play
+func (f fog) drawSpotlight(screen *ebiten.Image, gray uint8, xOrigin, width, height int, mirrored bool) {
+
+	if f.spotlight.amount <= 0 {
+		return
+	}
+
+	radius := float64(fogSpotlightMaxRadius) - f.spotlight.position()
+	dark := uint8(int(gray) / 3)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			xGrid := x
+			if mirrored {
+				xGrid = width - 1 - x
+			}
+			if !f.inSpotlight(float64(xGrid)+0.5, float64(y+gInvisibleLines)+0.5, radius) {
+				drawFogTexture(screen, float64(xOrigin+x*gSquareSideSize), float64(y*gSquareSideSize), float64(gSquareSideSize), float64(x%gPlayAreaWidthInBlocks*gSquareSideSize), y, dark)
+			}
+		}
+	}
+}
+
+// draw a line of fog, x and y in pixels, with the inside of the fog image
+// taken from xTexture and from the given line, width is at most the one of the image
+func drawFogTexture(screen *ebiten.Image, x, y, width, xTexture float64, line int, gray uint8) {
+	// the first line of the image is its edge
+	yTexture := (1 + line%(gPlayAreaHeightInBlocks-1)) * gSquareSideSize
+	inside := assets.ImageFog.SubImage(image.Rect(int(xTexture), yTexture, int(xTexture+width), yTexture+gSquareSideSize)).(*ebiten.Image)
+
+	options := ebiten.DrawImageOptions{}
+	options.GeoM.Translate(x, y)
+	options.ColorScale.ScaleWithColor(color.Gray{gray})
+	screen.DrawImage(inside, &options)
+}
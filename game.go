@@ -18,35 +18,68 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 */
 package main
 
-import "github.com/loig/ebitenginegamejam2024/assets"
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/loig/ebitenginegamejam2024/assets"
+)
 
 const (
 	stateTitle int = iota
 	statePlay
 	stateBalance
-	stateLost
+	stateRunEnd // the score of a lost or won run is converted into coins
 	stateImprove
 	stateWon
 	stateControls
 	stateCredits
+	stateDemo
+	stateModes
+	stateVersus
+	stateOnline
+	stateCoop
+	stateDaily
 )
 
+// entries of the title screen, from top to bottom
+const (
+	titlePlay int = iota
+	titleCredits
+	titleDaily
+	numTitleEntries
+)
+
+const attractDelayFrames int = 600 // frames of inactivity on the title screen before the demo starts
+
 type game struct {
-	state       int
-	firstPlay   bool
-	currentPlay tetris
-	level       int
-	goalLevel   int
-	balance     balancing
-	numChoices  int
-	audio       assets.SoundManager
-	money       moneyHandler
-	improv      improvements
-	fog         fog
-	titleSelect int
-	titleFrame  int
-	winFrame    int
-	inputs      KeyboardInputs
+	state          int
+	firstPlay      bool
+	currentPlay    tetris
+	level          int
+	goalLevel      int
+	balance        balancing
+	numChoices     int
+	audio          assets.SoundManager
+	money          moneyHandler
+	improv         improvements
+	fog            fog
+	titleSelect    int
+	modeSelect     int
+	boardSelect    int // index of the play area size in boardSizes, for the adventure
+	pieceSetSelect int // index of the piece set in pieceSets, for the piece sets mode
+	titleFrame     int
+	idleFrames     int
+	bot            bot
+	versus         versus
+	online         *netVersus
+	coop           coop
+	daily          daily
+	ascension      ascension
+	runGoalLevel   int  // levels to complete in the current run of the adventure
+	runCoinRate    int  // percent of the score converted into coins at the end of the current run
+	newTier        bool // the last win unlocked an ascension tier
+	winFrame       int
+	playScreen     *ebiten.Image // offscreen image for drawing the play area before fitting it to the screen
+	inputs         KeyboardInputs
 }
 
 func (g *game) init() {
@@ -56,6 +89,8 @@ func (g *game) init() {
 	g.numChoices = 3
 	g.improv = setupImprovements()
 	g.goalLevel = 11
+	g.daily = loadDaily()
+	g.ascension = loadAscension(profileName)
 
 	switch selectedKeyBind {
 	case 1:
@@ -31,10 +31,11 @@ const (
 	gPlayAreaHeight int = gPlayAreaHeightInBlocks * gSquareSideSize // height of play area in pixels
 	gPlayAreaSide   int = 9 * gMultFactor                           // play area side shift in pixels
 
-	gInfoLeftSide       int = 8 * gMultFactor  // info left side shift in pixels
-	gInfoWidth          int = 46 * gMultFactor // info width in pixels
-	gInfoRightSide      int = 8 * gMultFactor  // info right side shift in pixels
-	gInfoBoxHeight      int = 22 * gMultFactor // height of standard info box in pixels
+	gInfoLeftSide       int = 8 * gMultFactor                             // info left side shift in pixels
+	gInfoWidth          int = 46 * gMultFactor                            // info width in pixels
+	gInfoRightSide      int = 8 * gMultFactor                             // info right side shift in pixels
+	gInfoPanelWidth     int = gInfoLeftSide + gInfoWidth + gInfoRightSide // width of the info panel in pixels
+	gInfoBoxHeight      int = 22 * gMultFactor                            // height of standard info box in pixels
 	gNextMargin         int = 5 * gMultFactor
 	gNextBoxSide        int = 42 * gMultFactor // side of box displaying next piece in pixels
 	gInfoSmallBoxHeight int = 14 * gMultFactor // height of small info box
@@ -60,8 +61,6 @@ const (
 
 	gChoiceSelectionNumFrame int = 30 // number of frames for changing balancing choice
 
-	gSpeedLevels int = 21
-
 	gInvisibleNumFrames int = 60 // num frames for one step of invisibility
 
 	gCoinSideSize int = 128 // size of the side of the coin image in pixels
@@ -95,12 +94,14 @@ const (
 
 	// size of hearts in pixels
 	gHeartWidth int = 70
-)
 
-var gSpeeds [gSpeedLevels]int = [gSpeedLevels]int{
-	53, 49, 45, 41, 37, 33, 28, 22, 17, 11, 10,
-	9, 8, 7, 6, 6, 5, 5, 4, 4, 3,
-}
+	gImprovePerPage int = 4 // number of improvements displayed at once in the shop
+
+	// size of a character of text drawn without a dedicated image, in pixels
+	gTextCharWidth  int     = 6
+	gTextCharHeight int     = 16
+	gTextScale      float64 = 3 // scaling of text drawn without a dedicated image
+)
 
 var gAnimRocket []int = []int{
 	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
@@ -116,3 +117,20 @@ var gAnimRocket []int = []int{
 }
 
 var selectedKeyBind int
+
+var botGames, botMaxPieces int
+
+// relay and room used by the online versus mode
+var relayAddress, relayRoom string
+
+// file defining piece sets added to the built-in ones
+var piecesFile string
+
+// name of the timing used by all the modes instead of their own
+var timingName string
+
+// cascade gravity after line clears in the local modes
+var cascadeGravity bool
+
+// name under which the ascension tiers are saved
+var profileName string
@@ -0,0 +1,159 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/loig/ebitenginegamejam2024/assets"
+)
+
+const (
+	classicGravityName string = "CLASSIC" // curve used when none is given
+	versusGravityName  string = "VERSUS"
+	maxGravity         int    = 20 // in G, faster blocks would only fall further than the bottom
+)
+
+// speed at which blocks fall on their own: rows rows every frames frames,
+// so rows/frames is the gravity in G
+type gravity struct {
+	rows   int
+	frames int
+}
+
+// speeds of the blocks for each speed level
+type gravityCurve struct {
+	name   string
+	speeds []gravity
+}
+
+// gravity curves known by the game, read from assets/gravity.txt
+var gravityCurves []gravityCurve
+
+func loadGravityCurves() (err error) {
+	gravityCurves, err = parseGravityCurves(bytes.NewReader(assets.GravityCurves))
+	if err != nil {
+		return fmt.Errorf("gravity curves: %w", err)
+	}
+	if getGravityCurve(classicGravityName) == nil {
+		return fmt.Errorf("gravity curves: no %s curve", classicGravityName)
+	}
+	return nil
+}
+
+// find a gravity curve by its name, nil if it does not exist
+func getGravityCurve(name string) *gravityCurve {
+	for i := range gravityCurves {
+		if gravityCurves[i].name == name {
+			return &gravityCurves[i]
+		}
+	}
+	return nil
+}
+
+// read gravity curves in the format described in assets/gravity.txt
+func parseGravityCurves(r io.Reader) (curves []gravityCurve, err error) {
+	lineNum := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if fields[0] == "curve" {
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: a curve needs a name", lineNum)
+			}
+			curves = append(curves, gravityCurve{name: strings.ToUpper(fields[1])})
+			continue
+		}
+		if len(curves) == 0 {
+			return nil, fmt.Errorf("line %d: speed outside of a curve", lineNum)
+		}
+		curve := &curves[len(curves)-1]
+		for _, field := range fields {
+			speed, err := parseGravity(field)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			curve.speeds = append(curve.speeds, speed)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, curve := range curves {
+		if len(curve.speeds) == 0 {
+			return nil, fmt.Errorf("curve %s has no speeds", curve.name)
+		}
+	}
+	return curves, nil
+}
+
+// read a speed written as "1/53G" or "20G"
+func parseGravity(s string) (g gravity, err error) {
+	value, ok := strings.CutSuffix(strings.ToUpper(s), "G")
+	if !ok {
+		return g, fmt.Errorf("speed %s is not given in G", s)
+	}
+	rows, frames, fraction := strings.Cut(value, "/")
+	if g.rows, err = strconv.Atoi(rows); err != nil {
+		return g, fmt.Errorf("invalid speed %s", s)
+	}
+	g.frames = 1
+	if fraction {
+		if g.frames, err = strconv.Atoi(frames); err != nil {
+			return g, fmt.Errorf("invalid speed %s", s)
+		}
+	}
+	if g.rows <= 0 || g.frames <= 0 {
+		return g, fmt.Errorf("speed %s is not positive", s)
+	}
+	if g.rows > maxGravity*g.frames {
+		return g, fmt.Errorf("speed %s is above %dG", s, maxGravity)
+	}
+	return g, nil
+}
+
+// speed of a level, levels above the curve have its last speed,
+// the classic curve is used if c is nil
+func (c *gravityCurve) at(level int) gravity {
+	if c == nil {
+		c = getGravityCurve(classicGravityName)
+	}
+	return c.speeds[max(0, min(level, len(c.speeds)-1))]
+}
+
+// number of rows to fall during one frame, progress is the part
+// of a row already done, counted in 1/frames of a row
+func (g gravity) fall(progress *int) (rows int) {
+	*progress += g.rows
+	rows = *progress / g.frames
+	*progress %= g.frames
+	return
+}
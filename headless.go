@@ -0,0 +1,52 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import "fmt"
+
+// play one level with a bot without window nor audio,
+// until the goal is reached, the game is lost or maxPieces are placed
+// (a negative goal or maxPieces means no limit),
+// and return the number of frames played
+func playBotLevel(t *tetris, b *bot, level, goalLines, maxPieces int) (frames int) {
+	for !t.dead || t.inAnimation {
+		if !t.inAnimation && goalLines >= 0 && t.numLines >= goalLines {
+			return
+		}
+		if maxPieces >= 0 && t.pieces >= maxPieces {
+			return
+		}
+		inputs := b.update(*t)
+		t.update(inputs.down, inputs.left, inputs.right, inputs.hold, inputs.rotateLeft, inputs.rotateRight, false, false, level)
+		frames++
+	}
+	return
+}
+
+// play games with the default bot without window nor audio and print the results
+func runBotGames(numGames, maxPieces int) {
+	for game := 0; game < numGames; game++ {
+		effects := setupImprovements().getEffects()
+		t := tetris{}
+		t.init(0, newBalance(0, effects), 0, 0, effects, effects.life)
+		b := newBot(defaultBotHeuristic)
+		playBotLevel(&t, &b, 0, -1, maxPieces)
+		fmt.Printf("game %d: score %d, lines %d, pieces %d, lost %t\n", game+1, t.score, t.numLines, t.pieces, t.dead)
+	}
+}
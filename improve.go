@@ -36,29 +36,214 @@ const (
 	improveHold
 	improveResetAutoDown
 	improveHideMove
+	improveReroll
+	improveSkip
+	improvePreviews
+	improveGhost
+	improveUndo
+	improveShield
+	improveCoinBonus
 	numImprove
 )
 
+// effects of the improvements bought in the shop
+type improvementEffects struct {
+	life           int
+	canHold        bool
+	betterRotation bool
+	fogProtection  int
+	rerolls        int
+	skips          int
+	previews       int // number of blocks shown after the next one
+	showGhost      bool
+	undos          int // number of undo per level
+	shields        int // number of death zone shields per level
+	coinBonus      int // bonus on coin multiplier, in percent
+}
+
+// description of an improvement that can be bought in the shop
+type improvement struct {
+	id          int                                          // identifier of the improvement
+	prices      []int                                        // price of each level of the improvement
+	effect      func(level int, effects *improvementEffects) // apply the improvement at a given level
+	icon        int                                          // position in assets.ImageImprovements, -1 if none
+	text        int                                          // position in assets.ImageTextShop, -1 if none
+	name        string                                       // displayed if there is no icon
+	description string                                       // displayed if there is no text
+}
+
+var improvementCatalog []improvement = []improvement{
+	{
+		id:     improveLife,
+		prices: []int{10, 50, 150},
+		effect: func(level int, effects *improvementEffects) {
+			effects.life = level*2 - 1
+		},
+		icon: 0, text: 0,
+	},
+	{
+		id:     improveHold,
+		prices: []int{150},
+		effect: func(level int, effects *improvementEffects) {
+			effects.canHold = level > 0
+		},
+		icon: 1, text: 1,
+	},
+	{
+		id:     improveResetAutoDown,
+		prices: []int{300},
+		effect: func(level int, effects *improvementEffects) {
+			effects.betterRotation = level > 0
+		},
+		icon: 2, text: 2,
+	},
+	{
+		id:     improveHideMove,
+		prices: []int{20, 75, 250},
+		effect: func(level int, effects *improvementEffects) {
+			effects.fogProtection = level
+		},
+		icon: 3, text: 3,
+	},
+	{
+		id:     improveReroll,
+		prices: []int{40, 120, 300},
+		effect: func(level int, effects *improvementEffects) {
+			effects.rerolls = level
+		},
+		icon: -1, text: -1,
+		name:        "REROLL",
+		description: "REDRAW THE MALUSES OFFERED\nAT THE END OF A LEVEL\nEACH LEVEL GIVES ONE USE PER RUN",
+	},
+	{
+		id:     improveSkip,
+		prices: []int{60, 200},
+		effect: func(level int, effects *improvementEffects) {
+			effects.skips = level
+		},
+		icon: -1, text: -1,
+		name:        "SKIP",
+		description: "PAY COINS TO SKIP A MALUS\nAT THE END OF A LEVEL\nEACH LEVEL GIVES ONE USE PER RUN",
+	},
+	{
+		id:     improvePreviews,
+		prices: []int{80, 200},
+		effect: func(level int, effects *improvementEffects) {
+			effects.previews = level
+		},
+		icon: -1, text: -1,
+		name:        "PREVIEW",
+		description: "SEE MORE TETROMINOES\nCOMING AFTER THE NEXT ONE",
+	},
+	{
+		id:     improveGhost,
+		prices: []int{100},
+		effect: func(level int, effects *improvementEffects) {
+			effects.showGhost = level > 0
+		},
+		icon: -1, text: -1,
+		name:        "GHOST",
+		description: "SEE WHERE THE CURRENT\nTETROMINO WILL LAND",
+	},
+	{
+		id:     improveUndo,
+		prices: []int{250, 600},
+		effect: func(level int, effects *improvementEffects) {
+			effects.undos = level
+		},
+		icon: -1, text: -1,
+		name:        "UNDO",
+		description: "CANCEL THE LAST TETROMINO\nPLACED BY PRESSING BACKSPACE\nUSABLE ONCE PER LEVEL, TWICE\nWHEN FULLY IMPROVED",
+	},
+	{
+		id:     improveShield,
+		prices: []int{120, 300},
+		effect: func(level int, effects *improvementEffects) {
+			effects.shields = level
+		},
+		icon: -1, text: -1,
+		name:        "SHIELD",
+		description: "CLEAR THE DANGER ZONE INSTEAD\nOF LOSING WHEN IT IS FULL\nUSABLE ONCE PER LEVEL, TWICE\nWHEN FULLY IMPROVED",
+	},
+	{
+		id:     improveCoinBonus,
+		prices: []int{50, 150, 400},
+		effect: func(level int, effects *improvementEffects) {
+			effects.coinBonus = 10 * level
+		},
+		icon: -1, text: -1,
+		name:        "COINS",
+		description: "GAIN TEN PERCENT MORE COINS\nAT THE END OF EACH RUN",
+	},
+}
+
 type improvements struct {
-	prices          [numImprove][]int
-	levels          [numImprove]int
-	current         int
+	catalog         []improvement
+	levels          []int
+	current         int // selected improvement, len(catalog) for continue
+	first           int // first improvement displayed in the shop
 	arrowBlinkFrame int
 }
 
 func setupImprovements() (imp improvements) {
-	imp.prices[improveLife] = []int{10, 50, 150}
-	imp.prices[improveHold] = []int{150}
-	imp.prices[improveResetAutoDown] = []int{300}
-	imp.prices[improveHideMove] = []int{20, 75, 250}
+	imp.catalog = improvementCatalog
+	imp.levels = make([]int, len(imp.catalog))
+	return
+}
+
+// get the level of an improvement from its identifier
+func (i improvements) getLevel(id int) int {
+	for pos, imp := range i.catalog {
+		if imp.id == id {
+			return i.levels[pos]
+		}
+	}
+	return 0
+}
+
+// get the effects of all the improvements bought
+func (i improvements) getEffects() (effects improvementEffects) {
+	for pos, imp := range i.catalog {
+		if imp.effect != nil {
+			imp.effect(i.levels[pos], &effects)
+		}
+	}
 	return
 }
 
+func (i improvements) isMaxed(pos int) bool {
+	return i.levels[pos] >= len(i.catalog[pos].prices)
+}
+
+// move the selection, skipping maxed improvements
+func (i *improvements) moveSelection(step int) {
+	numChoices := len(i.catalog) + 1
+	i.current = (i.current + step + numChoices) % numChoices
+	for i.current != len(i.catalog) && i.isMaxed(i.current) {
+		i.current = (i.current + step + numChoices) % numChoices
+	}
+	i.scrollToCurrent()
+}
+
+// update the first displayed improvement so that the selected one is visible
+func (i *improvements) scrollToCurrent() {
+	if i.current >= len(i.catalog) {
+		return
+	}
+	if i.current < i.first {
+		i.first = i.current
+	}
+	if i.current >= i.first+gImprovePerPage {
+		i.first = i.current - gImprovePerPage + 1
+	}
+}
+
 func (i *improvements) reset() {
 	i.arrowBlinkFrame = 0
 	i.current = 0
-	for i.current != numImprove && i.levels[i.current] >= len(i.prices[i.current]) {
-		i.current = (i.current + 1) % (numImprove + 1)
+	i.first = 0
+	if len(i.catalog) > 0 && i.isMaxed(0) {
+		i.moveSelection(1)
 	}
 }
 
@@ -89,12 +274,24 @@ func drawContinue(screen *ebiten.Image, x, y int, selected bool, blink int) {
 	}
 }
 
-func drawShopText(screen *ebiten.Image, x, y int, selection int) {
-	if selection < numImprove {
-		options := ebiten.DrawImageOptions{}
-		options.GeoM.Translate(float64(x), float64(y))
-		screen.DrawImage(assets.ImageTextShop.SubImage(image.Rect(0, selection*gTextMalusHeight, gTextMalusWidth, (selection+1)*gTextMalusHeight)).(*ebiten.Image), &options)
+func drawShopText(screen *ebiten.Image, x, y int, imp improvement) {
+	if imp.text < 0 {
+		drawTextBanner(screen, imp.description, x, y, gTextMalusWidth, gTextMalusHeight)
+		return
+	}
+	options := ebiten.DrawImageOptions{}
+	options.GeoM.Translate(float64(x), float64(y))
+	screen.DrawImage(assets.ImageTextShop.SubImage(image.Rect(0, imp.text*gTextMalusHeight, gTextMalusWidth, (imp.text+1)*gTextMalusHeight)).(*ebiten.Image), &options)
+}
+
+func drawImprovementIcon(screen *ebiten.Image, x, y int, imp improvement) {
+	if imp.icon < 0 {
+		drawTextCentered(screen, imp.name, x+gImproveTextWidth/2, y+gImproveTextHeight/2, gTextScale, gTextColor)
+		return
 	}
+	options := ebiten.DrawImageOptions{}
+	options.GeoM.Translate(float64(x), float64(y))
+	screen.DrawImage(assets.ImageImprovements.SubImage(image.Rect(0, imp.icon*gImproveTextHeight, gImproveTextWidth, (imp.icon+1)*gImproveTextHeight)).(*ebiten.Image), &options)
 }
 
 func (g game) drawStateImprove(screen *ebiten.Image) {
@@ -104,25 +301,37 @@ func (g game) drawStateImprove(screen *ebiten.Image) {
 
 	drawMoney(screen, gWidth/2, yStart-gCoinSideSize, g.money.money, true, 1)
 
-	drawContinue(screen, (gWidth-gContinueWidth)/2, gHeight-gContinueHeight-gTitleMargin, g.improv.current == numImprove, g.improv.arrowBlinkFrame)
+	drawContinue(screen, (gWidth-gContinueWidth)/2, gHeight-gContinueHeight-gTitleMargin, g.improv.current == len(g.improv.catalog), g.improv.arrowBlinkFrame)
+
+	if g.improv.current < len(g.improv.catalog) {
+		drawShopText(screen, (gWidth-gTextMalusWidth)/2, gHeight-gContinueHeight-gTitleMargin-gTextMalusHeight-gTitleMargin, g.improv.catalog[g.improv.current])
+	}
 
-	drawShopText(screen, (gWidth-gTextMalusWidth)/2, gHeight-gContinueHeight-gTitleMargin-gTextMalusHeight-gTitleMargin, g.improv.current)
+	numDisplayed := len(g.improv.catalog) - g.improv.first
+	if numDisplayed > gImprovePerPage {
+		numDisplayed = gImprovePerPage
+	}
 
-	x := (gWidth - (4*gImproveTextWidth + 3*xSeparator)) / 2
+	x := (gWidth - (numDisplayed*gImproveTextWidth + (numDisplayed-1)*xSeparator)) / 2
 	y := yStart
 
-	for i := 0; i < numImprove; i++ {
+	// arrows showing that more improvements are available
+	if g.improv.first > 0 {
+		drawArrow(screen, x-xSeparator, y+(gImproveTextHeight+gArrowWidth)/2, -math.Pi/2, 0)
+	}
+	if g.improv.first+numDisplayed < len(g.improv.catalog) {
+		xEnd := x + numDisplayed*(gImproveTextWidth+xSeparator) - xSeparator
+		drawArrow(screen, xEnd+xSeparator, y+(gImproveTextHeight-gArrowWidth)/2, math.Pi/2, 0)
+	}
 
-		options := ebiten.DrawImageOptions{}
-		options.GeoM.Translate(float64(x), float64(y))
-		screen.DrawImage(assets.ImageImprovements.SubImage(image.Rect(0, i*gImproveTextHeight, gImproveTextWidth, (i+1)*gImproveTextWidth)).(*ebiten.Image), &options)
+	for i := g.improv.first; i < g.improv.first+numDisplayed; i++ {
 
-		if i != numImprove {
-			if len(g.improv.prices[i]) > g.improv.levels[i] {
-				drawMoney(screen, x+3*gImproveTextWidth/5, y+gImproveTextHeight, g.improv.prices[i][g.improv.levels[i]], false, 0.4)
-			} else {
-				drawMaxed(screen, x+(gImproveTextWidth-gMaxWidth)/2, y+gImproveTextHeight-14)
-			}
+		drawImprovementIcon(screen, x, y, g.improv.catalog[i])
+
+		if !g.improv.isMaxed(i) {
+			drawMoney(screen, x+3*gImproveTextWidth/5, y+gImproveTextHeight, g.improv.catalog[i].prices[g.improv.levels[i]], false, 0.4)
+		} else {
+			drawMaxed(screen, x+(gImproveTextWidth-gMaxWidth)/2, y+gImproveTextHeight-14)
 		}
 
 		if g.improv.current == i {
@@ -143,41 +352,34 @@ func (g *game) updateStateImprove() bool {
 
 	if inpututil.IsKeyJustPressed(ebiten.KeyLeft) {
 		g.audio.NextSounds[assets.SoundMenuMoveID] = true
-		g.improv.current = (g.improv.current + numImprove) % (numImprove + 1)
-		for g.improv.current != numImprove && g.improv.levels[g.improv.current] >= len(g.improv.prices[g.improv.current]) {
-			g.improv.current = (g.improv.current + numImprove) % (numImprove + 1)
-		}
+		g.improv.moveSelection(-1)
 	}
 
 	if inpututil.IsKeyJustPressed(ebiten.KeyRight) {
 		g.audio.NextSounds[assets.SoundMenuMoveID] = true
-		g.improv.current = (g.improv.current + 1) % (numImprove + 1)
-		for g.improv.current != numImprove && g.improv.levels[g.improv.current] >= len(g.improv.prices[g.improv.current]) {
-			g.improv.current = (g.improv.current + 1) % (numImprove + 1)
-		}
+		g.improv.moveSelection(1)
 	}
 
 	if inpututil.IsKeyJustPressed(ebiten.KeyDown) || inpututil.IsKeyJustPressed(ebiten.KeyUp) {
 		g.audio.NextSounds[assets.SoundMenuMoveID] = true
-		if g.improv.current != numImprove {
-			g.improv.current = numImprove
+		if g.improv.current != len(g.improv.catalog) {
+			g.improv.current = len(g.improv.catalog)
 		} else {
-			g.improv.current = 0
-			for g.improv.current != numImprove && g.improv.levels[g.improv.current] >= len(g.improv.prices[g.improv.current]) {
-				g.improv.current = (g.improv.current + 1) % (numImprove + 1)
-			}
+			g.improv.current = g.improv.first - 1
+			g.improv.moveSelection(1)
 		}
 	}
 
 	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
-		if g.improv.current == numImprove {
+		if g.improv.current == len(g.improv.catalog) {
 			g.audio.NextSounds[assets.SoundMenuConfirmID] = true
 			return true
 		}
 
-		if g.improv.levels[g.improv.current] < len(g.improv.prices[g.improv.current]) {
-			if g.improv.prices[g.improv.current][g.improv.levels[g.improv.current]] <= g.money.money {
-				g.money.money -= g.improv.prices[g.improv.current][g.improv.levels[g.improv.current]]
+		if !g.improv.isMaxed(g.improv.current) {
+			price := g.improv.catalog[g.improv.current].prices[g.improv.levels[g.improv.current]]
+			if price <= g.money.money {
+				g.money.money -= price
 				g.improv.levels[g.improv.current]++
 				g.audio.NextSounds[assets.SoundBuyID] = true
 			} else {
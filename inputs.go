@@ -10,10 +10,12 @@ var (
 		enter: ebiten.KeyEnter,
 		alt:   ebiten.KeyAlt,
 		space: ebiten.KeySpace,
+		half:  ebiten.KeyC,
 		up:    ebiten.KeyUp,
 		down:  ebiten.KeyDown,
 		left:  ebiten.KeyLeft,
 		right: ebiten.KeyRight,
+		undo:  ebiten.KeyBackspace,
 	}
 
 	// equivalent of zqsd for azerty keyboard
@@ -21,10 +23,38 @@ var (
 		enter: ebiten.KeyEnter,
 		alt:   ebiten.KeyAlt,
 		space: ebiten.KeySpace,
+		half:  ebiten.KeyC,
 		up:    ebiten.KeyZ,
 		down:  ebiten.KeyS,
 		left:  ebiten.KeyA,
 		right: ebiten.KeyD,
+		undo:  ebiten.KeyBackspace,
+	}
+
+	// left player of the versus and co-op modes
+	versusLeftKeys = keyboardMap{
+		enter: ebiten.KeyTab,
+		alt:   ebiten.KeyQ,
+		space: ebiten.KeyE,
+		half:  ebiten.KeyR,
+		up:    ebiten.KeyW,
+		down:  ebiten.KeyS,
+		left:  ebiten.KeyA,
+		right: ebiten.KeyD,
+		undo:  ebiten.KeyEscape,
+	}
+
+	// right player of the versus and co-op modes
+	versusRightKeys = keyboardMap{
+		enter: ebiten.KeyEnter,
+		alt:   ebiten.KeyComma,
+		space: ebiten.KeyPeriod,
+		half:  ebiten.KeySlash,
+		up:    ebiten.KeyUp,
+		down:  ebiten.KeyDown,
+		left:  ebiten.KeyLeft,
+		right: ebiten.KeyRight,
+		undo:  ebiten.KeyBackspace,
 	}
 )
 
@@ -32,10 +62,12 @@ type keyboardMap struct {
 	enter ebiten.Key
 	alt   ebiten.Key
 	space ebiten.Key
+	half  ebiten.Key // half turn
 	up    ebiten.Key
 	down  ebiten.Key
 	left  ebiten.Key
 	right ebiten.Key
+	undo  ebiten.Key
 }
 
 type KeyboardInputs struct {
@@ -43,18 +75,89 @@ type KeyboardInputs struct {
 	enter bool
 	alt   bool
 	space bool
+	half  bool
 	up    bool
 	down  bool
 	left  bool
 	right bool
+	undo  bool
+	// keys kept pressed, for initial rotation and initial hold
+	upPressed    bool
+	altPressed   bool
+	spacePressed bool
+	// keys just pressed, for menus
+	menuDown  bool
+	menuLeft  bool
+	menuRight bool
+	// optional gamepad, with a standard layout
+	usesGamepad bool
+	gamepad     ebiten.GamepadID
 }
 
 func (k *KeyboardInputs) update() {
 	k.enter = inpututil.IsKeyJustPressed(k.kmap.enter)
 	k.alt = inpututil.IsKeyJustPressed(k.kmap.alt)
 	k.space = inpututil.IsKeyJustPressed(k.kmap.space)
+	k.half = inpututil.IsKeyJustPressed(k.kmap.half)
 	k.up = inpututil.IsKeyJustPressed(k.kmap.up)
 	k.down = ebiten.IsKeyPressed(k.kmap.down)
 	k.left = ebiten.IsKeyPressed(k.kmap.left)
 	k.right = ebiten.IsKeyPressed(k.kmap.right)
+	k.undo = inpututil.IsKeyJustPressed(k.kmap.undo)
+	k.upPressed = ebiten.IsKeyPressed(k.kmap.up)
+	k.altPressed = ebiten.IsKeyPressed(k.kmap.alt)
+	k.spacePressed = ebiten.IsKeyPressed(k.kmap.space)
+	k.menuDown = inpututil.IsKeyJustPressed(k.kmap.down)
+	k.menuLeft = inpututil.IsKeyJustPressed(k.kmap.left)
+	k.menuRight = inpututil.IsKeyJustPressed(k.kmap.right)
+
+	if k.usesGamepad {
+		k.updateGamepad()
+	}
+}
+
+// add the inputs of the gamepad to the keyboard ones
+func (k *KeyboardInputs) updateGamepad() {
+	justPressed := func(b ebiten.StandardGamepadButton) bool {
+		return inpututil.IsStandardGamepadButtonJustPressed(k.gamepad, b)
+	}
+	pressed := func(b ebiten.StandardGamepadButton) bool {
+		return ebiten.IsStandardGamepadButtonPressed(k.gamepad, b)
+	}
+
+	k.enter = k.enter || justPressed(ebiten.StandardGamepadButtonCenterRight)
+	k.alt = k.alt || justPressed(ebiten.StandardGamepadButtonRightRight)
+	k.space = k.space || justPressed(ebiten.StandardGamepadButtonRightBottom)
+	k.half = k.half || justPressed(ebiten.StandardGamepadButtonRightTop)
+	k.up = k.up || justPressed(ebiten.StandardGamepadButtonLeftTop) || justPressed(ebiten.StandardGamepadButtonFrontTopLeft)
+	k.down = k.down || pressed(ebiten.StandardGamepadButtonLeftBottom)
+	k.left = k.left || pressed(ebiten.StandardGamepadButtonLeftLeft)
+	k.right = k.right || pressed(ebiten.StandardGamepadButtonLeftRight)
+	k.undo = k.undo || justPressed(ebiten.StandardGamepadButtonCenterLeft)
+	k.upPressed = k.upPressed || pressed(ebiten.StandardGamepadButtonLeftTop) || pressed(ebiten.StandardGamepadButtonFrontTopLeft)
+	k.altPressed = k.altPressed || pressed(ebiten.StandardGamepadButtonRightRight)
+	k.spacePressed = k.spacePressed || pressed(ebiten.StandardGamepadButtonRightBottom)
+	k.menuDown = k.menuDown || justPressed(ebiten.StandardGamepadButtonLeftBottom)
+	k.menuLeft = k.menuLeft || justPressed(ebiten.StandardGamepadButtonLeftLeft)
+	k.menuRight = k.menuRight || justPressed(ebiten.StandardGamepadButtonLeftRight)
+}
+
+// keys held for the next block to appear
+func (k KeyboardInputs) initialActions() initialActions {
+	return initialActions{hold: k.upPressed, rotateLeft: k.altPressed, rotateRight: k.spacePressed}
+}
+
+// give the connected gamepads with a standard layout to players, in order
+func assignGamepads(players []*KeyboardInputs) {
+	ids := ebiten.AppendGamepadIDs(nil)
+	pos := 0
+	for _, player := range players {
+		player.usesGamepad = false
+		for ; pos < len(ids) && !player.usesGamepad; pos++ {
+			if ebiten.IsStandardGamepadLayoutAvailable(ids[pos]) {
+				player.usesGamepad = true
+				player.gamepad = ids[pos]
+			}
+		}
+	}
 }
@@ -20,17 +20,60 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/loig/ebitenginegamejam2024/assets"
 )
 
 func init() {
 	flag.IntVar(&selectedKeyBind, "k", 0, "Select the keybind you want to use:\n- 1 for wasd\n- 0 or nothing for default")
+	flag.IntVar(&botGames, "bot-games", 0, "Play this number of games with the bot, without window, and print the results")
+	flag.IntVar(&botMaxPieces, "bot-pieces", 1000, "Maximum number of pieces placed in each game played with -bot-games (-1 for no limit)")
+	flag.StringVar(&relayAddress, "relay", "localhost:7777", "Address of the relay used by the online versus mode")
+	flag.StringVar(&relayRoom, "room", "yatc", "Room to join on the relay, players in the same room play together")
+	flag.StringVar(&piecesFile, "pieces", "", "File defining piece sets, added to the built-in ones (see assets/pieces.txt for the format)")
+	flag.StringVar(&timingName, "timing", "", "Line clear and spawn delays used by all modes: CLASSIC, VERSUS, NES or INSTANT (each mode has its own if not given)")
+	flag.BoolVar(&cascadeGravity, "cascade", false, "Make groups of connected squares fall after line clears, with chain clears, in the local modes")
+	flag.StringVar(&profileName, "profile", "default", "Profile under which the unlocked ascension tiers are saved")
 	flag.Parse()
 }
 
 func main() {
 
+	if timingName = strings.ToUpper(timingName); timingName != "" && getTiming(timingName) == nil {
+		fmt.Fprintf(os.Stderr, "unknown timing %q\n", timingName)
+		os.Exit(1)
+	}
+	if err := loadGravityCurves(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := loadPieceSets(piecesFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	subcommands := map[string]func(args []string) error{
+		"sim":    runSimulation,
+		"env":    runEnvironment,
+		"netbot": runNetBot,
+	}
+	if run, ok := subcommands[flag.Arg(0)]; ok {
+		if err := run(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if botGames > 0 {
+		runBotGames(botGames, botMaxPieces)
+		return
+	}
+
 	g := game{}
 	g.init()
 
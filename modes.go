@@ -0,0 +1,150 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/loig/ebitenginegamejam2024/assets"
+)
+
+// game modes selectable after choosing play on the title screen
+const (
+	modeAdventure int = iota
+	modePieces
+	modeVersus
+	modeCoop
+	modeOnline
+	numModes
+)
+
+type modeDefinition struct {
+	name        string
+	description string
+}
+
+var modeDefinitions [numModes]modeDefinition = [numModes]modeDefinition{
+	modeAdventure: {
+		name:        "ADVENTURE",
+		description: "CLEAR LEVELS, CHOOSE MALUSES\nAND SPEND YOUR COINS IN THE SHOP",
+	},
+	modePieces: {
+		name:        "PIECE SETS",
+		description: "THE ADVENTURE WITH OTHER PIECES\nADD YOURS WITH -pieces",
+	},
+	modeVersus: {
+		name:        "VERSUS",
+		description: "TWO PLAYERS ON ONE MACHINE\nCLEAR LINES TO SEND GARBAGE",
+	},
+	modeCoop: {
+		name:        "CO-OP",
+		description: "TWO PLAYERS ON ONE WIDE AREA\nSHARING LINES, SCORE AND MALUSES",
+	},
+	modeOnline: {
+		name:        "ONLINE VERSUS",
+		description: "PLAY VERSUS THROUGH A RELAY\nSET WITH -relay AND -room",
+	},
+}
+
+const gModeLineHeight int = 110 // distance in pixels between two modes in the list
+
+// move in the list of modes, select one with enter or go back to title
+func (g *game) updateStateModes() (selected, back bool) {
+	if g.inputs.up {
+		g.audio.NextSounds[assets.SoundMenuMoveID] = true
+		g.modeSelect = (g.modeSelect + numModes - 1) % numModes
+	}
+	if g.inputs.menuDown {
+		g.audio.NextSounds[assets.SoundMenuMoveID] = true
+		g.modeSelect = (g.modeSelect + 1) % numModes
+	}
+
+	if g.modeSelect == modeAdventure && (g.inputs.menuLeft || g.inputs.menuRight) {
+		g.audio.NextSounds[assets.SoundMenuMoveID] = true
+		direction := 1
+		if g.inputs.menuLeft {
+			direction = len(boardSizes) - 1
+		}
+		g.boardSelect = (g.boardSelect + direction) % len(boardSizes)
+	}
+
+	if g.modeSelect == modePieces && len(pieceSets) > 0 && (g.inputs.menuLeft || g.inputs.menuRight) {
+		g.audio.NextSounds[assets.SoundMenuMoveID] = true
+		direction := 1
+		if g.inputs.menuLeft {
+			direction = len(pieceSets) - 1
+		}
+		// the sets too wide for the play area of the adventure are skipped
+		for range pieceSets {
+			g.pieceSetSelect = (g.pieceSetSelect + direction) % len(pieceSets)
+			if g.pieceSetFits() {
+				break
+			}
+		}
+	}
+
+	if g.inputs.undo {
+		g.audio.NextSounds[assets.SoundMenuNoID] = true
+		return false, true
+	}
+
+	selected = g.inputs.enter
+	if selected && g.modeSelect == modePieces && (len(pieceSets) == 0 || !g.pieceSetFits()) {
+		g.audio.NextSounds[assets.SoundMenuNoID] = true
+		return false, false
+	}
+	g.audio.NextSounds[assets.SoundMenuConfirmID] = selected
+	return
+}
+
+// check that the selected piece set can be played on the selected play area
+func (g game) pieceSetFits() bool {
+	return pieceSets[g.pieceSetSelect].checkWidth(boardSizes[g.boardSelect].width) == nil
+}
+
+func (g game) drawStateModes(screen *ebiten.Image) {
+	screen.DrawImage(assets.ImageShopBack, &ebiten.DrawImageOptions{})
+
+	drawTextCentered(screen, "CHOOSE A MODE", gWidth/2, 3*gTitleMargin+gTextCharHeight*int(gTextScale), 2*gTextScale, gTextColor)
+
+	yStart := gHeight/2 - numModes*gModeLineHeight/2
+	for mode, definition := range modeDefinitions {
+		y := yStart + mode*gModeLineHeight
+		drawTextCentered(screen, definition.name, gWidth/2, y, 1.5*gTextScale, gTextColor)
+		if mode == g.modeSelect {
+			width, _ := textSize(definition.name, 1.5*gTextScale)
+			drawArrow(screen, gWidth/2-width/2-20, y-gArrowWidth/2, math.Pi/2, g.titleFrame)
+		}
+	}
+
+	switch g.modeSelect {
+	case modeAdventure:
+		drawTextCentered(screen, "< "+boardSizes[g.boardSelect].name+" >", gWidth/2, 3*gHeight/4-gTitleMargin, gTextScale, gTextColor)
+	case modePieces:
+		if len(pieceSets) > 0 {
+			drawTextCentered(screen, "< "+pieceSets[g.pieceSetSelect].name+" >", gWidth/2, 3*gHeight/4-gTitleMargin, gTextScale, gTextColor)
+			if !g.pieceSetFits() {
+				drawTextCentered(screen, "TOO WIDE FOR "+boardSizes[g.boardSelect].name, gWidth/2, 3*gHeight/4-gTitleMargin-gTextCharHeight*int(gTextScale), gTextScale/1.5, gMalusColor)
+			}
+		}
+	}
+	drawTextCentered(screen, modeDefinitions[g.modeSelect].description, gWidth/2, 3*gHeight/4+2*gTitleMargin, gTextScale, gTextColor)
+	drawTextCentered(screen, "BACKSPACE: BACK TO TITLE", gWidth/2, gHeight-5*gTitleMargin, gTextScale/1.5, gTextColor)
+}
@@ -39,6 +39,7 @@ type moneyHandler struct {
 	score              int
 	count              int
 	nextCoin           int
+	scorePerCoin       int
 	previousMoney      int
 	scoreReduction     int
 	coins              []coinAnimator
@@ -101,10 +102,15 @@ func (c coinAnimator) draw(screen *ebiten.Image) {
 	screen.DrawImage(assets.ImageCoin, &options)
 }
 
-func (m *moneyHandler) addScore(score int) {
+// multiplier is given in percent
+func (m *moneyHandler) addScore(score int, multiplier int) {
+	m.scorePerCoin = 100 * scoreToMoney / multiplier
+	if m.scorePerCoin < 1 {
+		m.scorePerCoin = 1
+	}
 	m.displayMoney = m.money
 	m.previousMoney = m.money
-	m.money += score / scoreToMoney
+	m.money += score / m.scorePerCoin
 	m.score = score
 	m.count = 0
 	m.nextCoin = 0
@@ -116,6 +122,12 @@ func (m *moneyHandler) addScore(score int) {
 	m.numActive = 0
 }
 
+// coins collected during a level, spendable right away
+func (m *moneyHandler) addCoins(coins int) {
+	m.money += coins
+	m.displayMoney = m.money
+}
+
 func (m *moneyHandler) update() (finished bool, playSounds [assets.NumSounds]bool) {
 
 	if m.score > 0 {
@@ -131,8 +143,8 @@ func (m *moneyHandler) update() (finished bool, playSounds [assets.NumSounds]boo
 			m.count = 0
 		}
 
-		if m.nextCoin/100 > 0 {
-			m.nextCoin -= 100
+		if m.nextCoin/m.scorePerCoin > 0 {
+			m.nextCoin -= m.scorePerCoin
 			m.numActive++
 			theCoin := newCoinAnimator(gWidth-gXScoreFromRightSide+gMultFactor-gSquareSideSize/2, gYScoreFromTop+gSquareSideSize/2, gWidth/2, 3*gHeight/4)
 			if m.firstAvailableCoin >= len(m.coins) {
@@ -171,7 +183,7 @@ func (m *moneyHandler) update() (finished bool, playSounds [assets.NumSounds]boo
 		}
 		m.nextCoin += m.score
 		m.score = 0
-		m.displayMoney += m.nextCoin / 100
+		m.displayMoney += m.nextCoin / m.scorePerCoin
 		m.nextCoin = 0
 	}
 
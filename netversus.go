@@ -0,0 +1,437 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/loig/ebitenginegamejam2024/assets"
+	"github.com/loig/ebitenginegamejam2024/relay"
+)
+
+// steps of an online match
+const (
+	netConnecting int = iota
+	netWaiting
+	netPlaying
+	netOver
+	netFailed
+)
+
+const (
+	netInputDelay   int           = 3  // frames between an input and its effect, hiding the latency
+	netHashInterval int           = 60 // frames between two checks of synchronization
+	netMaxCatchUp   int           = 4  // maximum number of frames simulated in one update
+	netDialTimeout  time.Duration = 5 * time.Second
+)
+
+// types of messages exchanged by the players through the relay
+const (
+	netTypeInput string = "input"
+	netTypeHash  string = "hash"
+)
+
+// keys of a player for one frame, packed in one int
+const (
+	netKeyDown int = 1 << iota
+	netKeyLeft
+	netKeyRight
+	netKeyHold
+	netKeyRotateLeft
+	netKeyRotateRight
+	// keys kept pressed, for initial rotation and initial hold
+	netKeyHoldPressed
+	netKeyRotateLeftPressed
+	netKeyRotateRightPressed
+	netKeyRotateHalf
+)
+
+// messages of the online versus mode, one JSON object per line
+type netMessage struct {
+	Type    string `json:"type"`
+	Room    string `json:"room,omitempty"`
+	Player  int    `json:"player"`
+	Seed    int64  `json:"seed,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Frame   int    `json:"frame"`
+	Keys    int    `json:"keys,omitempty"`
+	Hash    uint64 `json:"hash,omitempty"`
+	Garbage []int  `json:"garbage,omitempty"` // total garbage sent by each player
+}
+
+// online versus match, in lockstep: both players simulate both games and a
+// frame is only played once the inputs of both players for it are known
+type netVersus struct {
+	state        int
+	versus       versus
+	conn         io.ReadWriteCloser
+	connected    chan io.ReadWriteCloser
+	incoming     chan netMessage
+	done         chan struct{} // closed when the match is left, stops the connection goroutine
+	player       int           // index of the local player
+	frame        int           // next frame to simulate
+	inputFrame   int           // next frame for which the local input will be sent
+	inputs       [versusNumPlayers]map[int]int
+	hashes       [versusNumPlayers]map[int]netMessage // hash messages waiting for comparison
+	pendingKeys  int                                  // keys just pressed, not sent yet
+	hashChecks   int                                  // number of hashes compared
+	message      string
+	messageFrame int
+}
+
+// open a TCP connection to a relay
+func dialRelay(address string) func() (io.ReadWriteCloser, error) {
+	return func() (io.ReadWriteCloser, error) {
+		return net.DialTimeout("tcp", address, netDialTimeout)
+	}
+}
+
+// start connecting to a relay and joining a room
+func newNetVersus(dial func() (io.ReadWriteCloser, error), room string) *netVersus {
+	n := &netVersus{
+		state:     netConnecting,
+		connected: make(chan io.ReadWriteCloser),
+		incoming:  make(chan netMessage, 256),
+		done:      make(chan struct{}),
+		message:   "CONNECTING",
+	}
+	n.versus = newVersus()
+	n.versus.overText = "ENTER: BACK"
+	// both sides must play with the same delays and gravity, whatever their command line
+	n.versus.timing = getTiming(versusTimingName)
+	n.versus.cascade = false
+
+	go func() {
+		// messages are dropped once the match is left, nobody reads them anymore
+		deliver := func(m netMessage) bool {
+			select {
+			case n.incoming <- m:
+				return true
+			case <-n.done:
+				return false
+			}
+		}
+
+		conn, err := dial()
+		if err != nil {
+			deliver(netMessage{Type: relay.TypeError, Error: err.Error()})
+			return
+		}
+		// the connection is handed over before joining the room, so that
+		// close stops it if the match was left while dialing
+		select {
+		case n.connected <- conn:
+		case <-n.done:
+			conn.Close()
+			return
+		}
+		line, _ := json.Marshal(netMessage{Type: relay.TypeJoin, Room: room})
+		if _, err := conn.Write(append(line, '\n')); err != nil {
+			deliver(netMessage{Type: relay.TypeError, Error: err.Error()})
+			return
+		}
+
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 0, 1024), relay.MaxLineSize)
+		for scanner.Scan() {
+			m := netMessage{}
+			if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+				deliver(netMessage{Type: relay.TypeError, Error: err.Error()})
+				return
+			}
+			if !deliver(m) {
+				return
+			}
+		}
+		deliver(netMessage{Type: relay.TypeLeave})
+	}()
+
+	return n
+}
+
+func (n *netVersus) send(m netMessage) {
+	line, err := json.Marshal(m)
+	if err == nil {
+		_, err = n.conn.Write(append(line, '\n'))
+	}
+	if err != nil {
+		n.fail(err.Error())
+	}
+}
+
+func (n *netVersus) fail(message string) {
+	if n.state != netFailed && n.state != netOver {
+		n.state = netFailed
+		n.message = message
+	}
+}
+
+// leave the match: the connection is closed, even if it is still being
+// opened, and the goroutine reading it stops
+func (n *netVersus) close() {
+	select {
+	case <-n.done:
+		return
+	default:
+		close(n.done)
+	}
+	if n.conn == nil {
+		select {
+		case n.conn = <-n.connected:
+		default:
+		}
+	}
+	if n.conn != nil {
+		n.conn.Close()
+	}
+}
+
+// handle the messages received since the last update
+func (n *netVersus) receive() {
+	select {
+	case conn := <-n.connected:
+		n.conn = conn
+		n.state = netWaiting
+		n.message = "WAITING FOR AN OPPONENT"
+	default:
+	}
+
+	for {
+		var m netMessage
+		select {
+		case m = <-n.incoming:
+		default:
+			return
+		}
+
+		switch m.Type {
+		case relay.TypeError:
+			n.fail(m.Error)
+		case relay.TypeLeave:
+			message := "CONNECTION LOST"
+			if n.state == netPlaying {
+				message = "THE OPPONENT LEFT"
+			}
+			n.fail(message)
+		case relay.TypeStart:
+			n.start(m.Player, m.Seed)
+		case netTypeInput:
+			n.inputs[1-n.player][m.Frame] = m.Keys
+		case netTypeHash:
+			n.checkHash(1-n.player, m)
+		}
+	}
+}
+
+func (n *netVersus) start(player int, seed int64) {
+	n.state = netPlaying
+	n.player = player
+	n.versus.start(seed)
+	n.versus.names[player] = "YOU"
+	n.versus.names[1-player] = "OPPONENT"
+	for p := range n.inputs {
+		n.inputs[p] = make(map[int]int)
+		n.hashes[p] = make(map[int]netMessage)
+		for frame := 0; frame < netInputDelay; frame++ {
+			n.inputs[p][frame] = 0
+		}
+	}
+	n.frame = 0
+	n.inputFrame = netInputDelay
+	n.pendingKeys = 0
+	n.hashChecks = 0
+}
+
+// compare the hash of a player with the one of the other player for the same frame
+func (n *netVersus) checkHash(player int, m netMessage) {
+	other, ok := n.hashes[1-player][m.Frame]
+	if !ok {
+		n.hashes[player][m.Frame] = m
+		return
+	}
+	delete(n.hashes[1-player], m.Frame)
+	n.hashChecks++
+	if other.Hash != m.Hash || !slices.Equal(other.Garbage, m.Garbage) {
+		n.fail(fmt.Sprintf("DESYNC AT FRAME %d", m.Frame))
+	}
+}
+
+func netKeys(inputs KeyboardInputs) (keys int) {
+	for key, pressed := range map[int]bool{
+		netKeyDown:               inputs.down,
+		netKeyLeft:               inputs.left,
+		netKeyRight:              inputs.right,
+		netKeyHold:               inputs.up,
+		netKeyRotateLeft:         inputs.alt,
+		netKeyRotateRight:        inputs.space,
+		netKeyHoldPressed:        inputs.upPressed,
+		netKeyRotateLeftPressed:  inputs.altPressed,
+		netKeyRotateRightPressed: inputs.spacePressed,
+		netKeyRotateHalf:         inputs.half,
+	} {
+		if pressed {
+			keys |= key
+		}
+	}
+	return
+}
+
+func netInputs(keys int) KeyboardInputs {
+	return KeyboardInputs{
+		down:         keys&netKeyDown != 0,
+		left:         keys&netKeyLeft != 0,
+		right:        keys&netKeyRight != 0,
+		up:           keys&netKeyHold != 0,
+		alt:          keys&netKeyRotateLeft != 0,
+		space:        keys&netKeyRotateRight != 0,
+		upPressed:    keys&netKeyHoldPressed != 0,
+		altPressed:   keys&netKeyRotateLeftPressed != 0,
+		spacePressed: keys&netKeyRotateRightPressed != 0,
+		half:         keys&netKeyRotateHalf != 0,
+	}
+}
+
+// send the local inputs and play the frames for which all inputs are known,
+// returns true when going back to the modes screen
+func (n *netVersus) update(local KeyboardInputs) (quit bool, playSounds [assets.NumSounds]bool) {
+	n.receive()
+	n.messageFrame++
+
+	if n.state != netPlaying {
+		if local.enter || local.undo {
+			playSounds[assets.SoundMenuConfirmID] = true
+			n.close()
+			return true, playSounds
+		}
+		return
+	}
+
+	// keys that are only taken into account when just pressed are kept until sent
+	n.pendingKeys |= netKeys(KeyboardInputs{up: local.up, alt: local.alt, space: local.space, half: local.half})
+	if n.inputFrame < n.frame+2*netInputDelay {
+		keys := netKeys(KeyboardInputs{
+			down: local.down, left: local.left, right: local.right,
+			upPressed: local.upPressed, altPressed: local.altPressed, spacePressed: local.spacePressed,
+		}) | n.pendingKeys
+		n.inputs[n.player][n.inputFrame] = keys
+		n.send(netMessage{Type: netTypeInput, Player: n.player, Frame: n.inputFrame, Keys: keys})
+		n.inputFrame++
+		n.pendingKeys = 0
+	}
+
+	for step := 0; step < netMaxCatchUp && n.state == netPlaying; step++ {
+		for p := range n.inputs {
+			if _, ok := n.inputs[p][n.frame]; !ok {
+				return
+			}
+		}
+		for p := range n.inputs {
+			n.versus.players[p].inputs = netInputs(n.inputs[p][n.frame])
+			delete(n.inputs[p], n.frame)
+		}
+
+		sounds := n.versus.updatePlay()
+		for sound, play := range sounds {
+			playSounds[sound] = playSounds[sound] || play
+		}
+		n.frame++
+
+		if n.frame%netHashInterval == 0 {
+			m := netMessage{Type: netTypeHash, Player: n.player, Frame: n.frame, Hash: n.versus.hash(), Garbage: n.versus.garbageSent[:]}
+			n.send(m)
+			n.checkHash(n.player, m)
+		}
+
+		if n.versus.state == versusOver {
+			n.state = netOver
+			n.message = ""
+		}
+	}
+
+	return
+}
+
+func (n *netVersus) draw(screen *ebiten.Image) {
+	if n.state == netPlaying || n.state == netOver {
+		n.versus.draw(screen)
+		return
+	}
+
+	if n.state == netFailed && n.frame > 0 {
+		n.versus.draw(screen)
+	} else {
+		screen.Fill(gTextColor)
+	}
+
+	message := n.message
+	if n.state != netFailed {
+		message += [...]string{"", ".", "..", "..."}[(n.messageFrame/20)%4]
+	}
+	drawTextBanner(screen, message, (gWidth-gTextMalusWidth)/2, gHeight/2-gTextMalusHeight/2, gTextMalusWidth, gTextMalusHeight)
+	drawTextCentered(screen, "ENTER/BACKSPACE: BACK", gWidth/2, gHeight-4*gTitleMargin, gTextScale/1.5, gTextLightColor)
+}
+
+// run the netbot subcommand: play an online match with the bot, without window nor audio
+func runNetBot(args []string) error {
+	flags := flag.NewFlagSet("netbot", flag.ContinueOnError)
+	var address, room string
+	flags.StringVar(&address, "relay", "localhost:7777", "Address of the relay")
+	flags.StringVar(&room, "room", "yatc", "Room to join on the relay")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	n := newNetVersus(dialRelay(address), room)
+	defer n.close()
+	return playNetBot(n)
+}
+
+// play a match with the bot until it ends, checking that both games stay synchronized
+func playNetBot(n *netVersus) error {
+	b := newBot(defaultBotHeuristic)
+	for n.state != netOver && n.state != netFailed {
+		local := KeyboardInputs{}
+		if n.state == netPlaying {
+			inputs := b.update(n.versus.players[n.player].play)
+			local = KeyboardInputs{down: inputs.down, left: inputs.left, right: inputs.right, up: inputs.hold, alt: inputs.rotateLeft, space: inputs.rotateRight}
+		}
+		n.update(local)
+		time.Sleep(time.Millisecond)
+	}
+
+	if n.state == netFailed {
+		return errors.New(n.message)
+	}
+
+	result := "draw"
+	if n.versus.winner >= 0 {
+		result = "player " + fmt.Sprint(n.versus.winner+1) + " won"
+	}
+	fmt.Printf("player %d: %s after %d frames, %d hashes compared without desync\n", n.player+1, result, n.frame, n.hashChecks)
+	return nil
+}
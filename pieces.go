@@ -0,0 +1,263 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+
+	"github.com/loig/ebitenginegamejam2024/assets"
+)
+
+const (
+	weirdPieceSetName string = "WEIRD" // set giving the pieces of the weird pieces malus
+	maxPiecesInSet    int    = 100
+)
+
+// styles that can be given to pieces in definition files
+var pieceStyles map[string]int = map[string]int{
+	"I": iBlockStyle, "O": oBlockStyle, "J": jBlockStyle, "L": lBlockStyle,
+	"S": sBlockStyle, "T": tBlockStyle, "Z": zBlockStyle,
+}
+
+// a set of blocks the randomizer draws from, instead of the tetrominoes
+type pieceSet struct {
+	name   string
+	blocks []tetrisBlock
+}
+
+// piece sets known by the game: the built-in ones and those of the file given with -pieces
+var pieceSets []pieceSet
+
+// read the built-in piece sets, then the ones of a file if a name is given,
+// a set of the file replaces a built-in one with the same name
+func loadPieceSets(fileName string) error {
+	sets, err := parsePieceSets(bytes.NewReader(assets.PieceSets))
+	if err != nil {
+		return fmt.Errorf("built-in piece sets: %w", err)
+	}
+	pieceSets = sets
+
+	if fileName == "" {
+		return nil
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sets, err = parsePieceSets(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+
+SetLoop:
+	for _, set := range sets {
+		for i := range pieceSets {
+			if pieceSets[i].name == set.name {
+				pieceSets[i] = set
+				continue SetLoop
+			}
+		}
+		pieceSets = append(pieceSets, set)
+	}
+	return nil
+}
+
+// find a piece set by its name, nil if it does not exist
+func getPieceSet(name string) *pieceSet {
+	for i := range pieceSets {
+		if pieceSets[i].name == name {
+			return &pieceSets[i]
+		}
+	}
+	return nil
+}
+
+// read piece sets in the format described in assets/pieces.txt
+func parsePieceSets(r io.Reader) (sets []pieceSet, err error) {
+	var shape [][]bool
+	style := noStyle
+	lineNum := 0
+
+	// add the piece read so far to the last set
+	endPiece := func() error {
+		if shape == nil {
+			return nil
+		}
+		set := &sets[len(sets)-1]
+		if len(set.blocks) >= maxPiecesInSet {
+			return fmt.Errorf("line %d: more than %d pieces in set %s", lineNum, maxPiecesInSet, set.name)
+		}
+		block, err := newPolyomino(shape, style, int8(len(set.blocks)))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		set.blocks = append(set.blocks, block)
+		shape = nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "set":
+			if err := endPiece(); err != nil {
+				return nil, err
+			}
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: a set needs a name", lineNum)
+			}
+			sets = append(sets, pieceSet{name: strings.ToUpper(fields[1])})
+		case "piece":
+			if err := endPiece(); err != nil {
+				return nil, err
+			}
+			if len(sets) == 0 {
+				return nil, fmt.Errorf("line %d: piece outside of a set", lineNum)
+			}
+			if len(fields) < 2 || len(fields) > 3 {
+				return nil, fmt.Errorf("line %d: a piece needs a name and an optional style", lineNum)
+			}
+			// pieces without a style cycle through the sprites
+			style = iBlockStyle + len(sets[len(sets)-1].blocks)%(zBlockStyle-iBlockStyle+1)
+			if len(fields) == 3 {
+				var ok bool
+				if style, ok = pieceStyles[strings.ToUpper(fields[2])]; !ok {
+					return nil, fmt.Errorf("line %d: unknown style %s", lineNum, fields[2])
+				}
+			}
+			shape = [][]bool{}
+		default:
+			if shape == nil {
+				return nil, fmt.Errorf("line %d: shape outside of a piece", lineNum)
+			}
+			if strings.Trim(line, "#.") != "" {
+				return nil, fmt.Errorf("line %d: a shape only contains # and .", lineNum)
+			}
+			if len(line) > blockSize || len(shape) >= blockSize {
+				return nil, fmt.Errorf("line %d: shape larger than %dx%d", lineNum, blockSize, blockSize)
+			}
+			row := make([]bool, len(line))
+			for x, c := range line {
+				row[x] = c == '#'
+			}
+			shape = append(shape, row)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := endPiece(); err != nil {
+		return nil, err
+	}
+
+	for _, set := range sets {
+		if len(set.blocks) == 0 {
+			return nil, fmt.Errorf("set %s has no pieces", set.name)
+		}
+	}
+	return sets, nil
+}
+
+// build a block from its shape, the rotation states are obtained by
+// turning the smallest square containing the shape around its center
+func newPolyomino(shape [][]bool, style int, id int8) (block tetrisBlock, err error) {
+	height, width := 0, 0
+	squares := 0
+	for y, row := range shape {
+		for x, square := range row {
+			if square {
+				height, width = max(height, y+1), max(width, x+1)
+				squares++
+			}
+		}
+	}
+	if squares == 0 {
+		return block, fmt.Errorf("empty shape")
+	}
+	if height > blockSize || width > blockSize {
+		return block, fmt.Errorf("shape larger than %dx%d", blockSize, blockSize)
+	}
+
+	side := max(width, height)
+	yShift, xShift := (side-height)/2, (side-width)/2
+	for y, row := range shape {
+		for x, square := range row {
+			if square {
+				block.states[0][y+yShift][x+xShift] = true
+			}
+		}
+	}
+	// the states follow each other clockwise, as for the tetrominoes
+	for r := 1; r < len(block.states); r++ {
+		for y := 0; y < side; y++ {
+			for x := 0; x < side; x++ {
+				block.states[r][y][x] = block.states[r-1][side-1-x][y]
+			}
+		}
+	}
+
+	block.style = style
+	block.id = id
+	return block, nil
+}
+
+// number of columns needed by the widest block of the set, as it appears
+func (s pieceSet) width() (width int) {
+	for _, block := range s.blocks {
+		for _, line := range block.states[0] {
+			for x, square := range line {
+				if square {
+					width = max(width, x+1)
+				}
+			}
+		}
+	}
+	return
+}
+
+// check that the blocks of a set can appear on a play area of a given width
+func (s pieceSet) checkWidth(width int) error {
+	if s.width() > width {
+		return fmt.Errorf("piece set %q needs a width of at least %d", s.name, s.width())
+	}
+	return nil
+}
+
+// draw a block of the set, avoiding to give the same one again too often
+func (s pieceSet) getNewBlock(rng *rand.Rand, next tetrisBlock) (block tetrisBlock) {
+	block = s.blocks[rng.Intn(len(s.blocks))]
+	for count := 0; count < 2 && len(s.blocks) > 1 && block.id == next.id; count++ {
+		block = s.blocks[rng.Intn(len(s.blocks))]
+	}
+	return
+}
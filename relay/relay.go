@@ -0,0 +1,176 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package relay pairs the clients of the online versus mode and forwards
+// their messages to each other. Messages are JSON objects, one per line.
+// A client first sends {"type":"join","room":"name"}, then waits for
+// {"type":"start","player":0 or 1,"seed":n} which is sent once a second
+// client joins the same room. After that, every line sent by a client is
+// given to the other one, and {"type":"leave"} is sent to a client when its
+// opponent disconnects.
+package relay
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+)
+
+// Message is the part of the messages the relay needs to understand
+type Message struct {
+	Type   string `json:"type"`
+	Room   string `json:"room,omitempty"`
+	Player int    `json:"player"`
+	Seed   int64  `json:"seed,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// types of messages handled by the relay
+const (
+	TypeJoin  string = "join"
+	TypeStart string = "start"
+	TypeLeave string = "leave"
+	TypeError string = "error"
+)
+
+// MaxLineSize is the maximum size of a message, in bytes
+const MaxLineSize int = 64 * 1024
+
+type client struct {
+	conn    io.ReadWriteCloser
+	peer    chan *client // receives the opponent once paired
+	writeMu sync.Mutex
+}
+
+func (c *client) write(line []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(line)
+	return err
+}
+
+func (c *client) send(m Message) error {
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return c.write(append(line, '\n'))
+}
+
+// Relay holds the clients waiting for an opponent, by room
+type Relay struct {
+	mu      sync.Mutex
+	waiting map[string]*client
+}
+
+func New() *Relay {
+	return &Relay{waiting: make(map[string]*client)}
+}
+
+// Serve handles all the connections accepted by a listener
+func (r *Relay) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go r.Handle(conn)
+	}
+}
+
+// Handle serves one client until it disconnects
+func (r *Relay) Handle(conn io.ReadWriteCloser) {
+	defer conn.Close()
+
+	c := &client{conn: conn, peer: make(chan *client, 1)}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 1024), MaxLineSize)
+	if !scanner.Scan() {
+		return
+	}
+	join := Message{}
+	if err := json.Unmarshal(scanner.Bytes(), &join); err != nil || join.Type != TypeJoin {
+		c.send(Message{Type: TypeError, Error: "the first message must be a join"})
+		return
+	}
+
+	r.mu.Lock()
+	other, found := r.waiting[join.Room]
+	if found {
+		delete(r.waiting, join.Room)
+	} else {
+		r.waiting[join.Room] = c
+	}
+	r.mu.Unlock()
+
+	if found {
+		// the peers are known before the start messages are sent,
+		// so that no message following them can be lost
+		other.peer <- c
+		c.peer <- other
+		seed := rand.Int63()
+		other.send(Message{Type: TypeStart, Player: 0, Seed: seed})
+		c.send(Message{Type: TypeStart, Player: 1, Seed: seed})
+		log.Printf("room %q: match started", join.Room)
+	}
+
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		for scanner.Scan() {
+			lines <- append(append([]byte(nil), scanner.Bytes()...), '\n')
+		}
+	}()
+
+	var peer *client
+	for {
+		select {
+		case peer = <-c.peer:
+		case line, ok := <-lines:
+			if peer == nil {
+				select {
+				case peer = <-c.peer:
+				default:
+				}
+			}
+			if !ok {
+				if peer == nil {
+					r.mu.Lock()
+					if r.waiting[join.Room] == c {
+						delete(r.waiting, join.Room)
+					}
+					r.mu.Unlock()
+					return
+				}
+				peer.send(Message{Type: TypeLeave})
+				peer.conn.Close()
+				log.Printf("room %q: a player left", join.Room)
+				return
+			}
+			if peer != nil {
+				peer.write(line)
+			}
+		}
+	}
+}
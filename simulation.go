@@ -0,0 +1,408 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// policies for choosing maluses during simulations
+const (
+	simPolicyRandom  string = "random"  // any of the offered maluses
+	simPolicyFirst   string = "first"   // the first malus offered
+	simPolicySafe    string = "safe"    // the malus with the lowest coin reward
+	simPolicyGreedy  string = "greedy"  // the malus with the highest coin reward
+	simPolicySkipper string = "skipper" // skip whenever possible, else like random
+)
+
+const simFramesPerSecond float64 = 60
+
+// parameters of a batch of simulated runs
+type simConfig struct {
+	runs         int
+	seed         int64
+	policy       string
+	numChoices   int
+	goalLevel    int
+	improvements []int // level of each improvement, in catalog order
+	startMoney   int   // coins available for skipping maluses
+	maxPieces    int   // maximum number of pieces per level, the run is lost beyond
+	width        int   // size of the play area in squares
+	height       int
+	pieceSet     *pieceSet     // blocks given to the bot, the tetrominoes if nil
+	gravityCurve *gravityCurve // speeds of the blocks for each level
+	timing       *timing       // line clear and spawn delays
+	cascade      bool          // cascade gravity after line clears
+	workers      int
+}
+
+// result of one simulated run
+type simRun struct {
+	Seed          int64     `json:"seed"`
+	Won           bool      `json:"won"`
+	LevelReached  int       `json:"level_reached"`
+	TimedOut      bool      `json:"timed_out"`
+	Score         int       `json:"score"`
+	Lines         int       `json:"lines"`
+	Pieces        int       `json:"pieces"`
+	Coins         int       `json:"coins"`
+	Multiplier    int       `json:"multiplier"`
+	Maluses       []int     `json:"maluses"`
+	LevelSeconds  []float64 `json:"level_seconds"`
+	balanceLevels [numBalances]int
+}
+
+// aggregated results of a batch of simulated runs
+type simSummary struct {
+	Runs                int       `json:"runs"`
+	Policy              string    `json:"policy"`
+	WinRate             float64   `json:"win_rate"`
+	AverageLevel        float64   `json:"average_level"`
+	AverageCoins        float64   `json:"average_coins"`
+	AverageScore        float64   `json:"average_score"`
+	TimeoutRate         float64   `json:"timeout_rate"`
+	AverageLevelSeconds []float64 `json:"average_level_seconds"` // for each level, over the runs reaching its end
+}
+
+// run the sim subcommand with the given command line arguments
+func runSimulation(args []string) error {
+	flags := flag.NewFlagSet("sim", flag.ContinueOnError)
+	config := simConfig{}
+	var improvementsList, format, out, setName, gravityName, timingName string
+	flags.IntVar(&config.runs, "runs", 1000, "Number of runs to simulate")
+	flags.Int64Var(&config.seed, "seed", 1, "Seed of the first run, the following runs use the next seeds")
+	flags.StringVar(&config.policy, "policy", simPolicyRandom, "Malus choice policy: random, first, safe, greedy or skipper")
+	flags.IntVar(&config.numChoices, "choices", 3, "Number of maluses offered after each level")
+	flags.IntVar(&config.goalLevel, "goal-level", 11, "Number of levels to complete for winning a run")
+	flags.StringVar(&improvementsList, "improvements", "", "Comma separated levels of the shop improvements, in shop order")
+	flags.IntVar(&config.startMoney, "money", 0, "Coins available at the start of each run, for skipping maluses")
+	flags.IntVar(&config.maxPieces, "max-pieces", 400, "Maximum number of pieces in a level before the run is considered lost")
+	flags.IntVar(&config.workers, "workers", 4, "Number of runs simulated in parallel")
+	flags.IntVar(&config.width, "width", gPlayAreaWidthInBlocks, "Width of the play area in squares")
+	flags.IntVar(&config.height, "height", gPlayAreaHeightInBlocks, "Height of the play area in squares, without the invisible lines")
+	flags.StringVar(&setName, "set", "", "Piece set used instead of the tetrominoes")
+	flags.StringVar(&gravityName, "gravity", classicGravityName, "Gravity curve giving the speed of blocks for each level")
+	flags.StringVar(&timingName, "timing", classicTimingName, "Line clear and spawn delays: CLASSIC, VERSUS, NES or INSTANT")
+	flags.BoolVar(&config.cascade, "cascade", false, "Make groups of connected squares fall after line clears, with chain clears")
+	flags.StringVar(&format, "format", "csv", "Format of the report: csv or json")
+	flags.StringVar(&out, "out", "", "File to write the report to (nothing written if empty)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	switch config.policy {
+	case simPolicyRandom, simPolicyFirst, simPolicySafe, simPolicyGreedy, simPolicySkipper:
+	default:
+		return fmt.Errorf("unknown policy %q", config.policy)
+	}
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if err := checkBoardSize(config.width, config.height); err != nil {
+		return err
+	}
+	if setName != "" {
+		if config.pieceSet = getPieceSet(strings.ToUpper(setName)); config.pieceSet == nil {
+			return fmt.Errorf("unknown piece set %q", setName)
+		}
+		if err := config.pieceSet.checkWidth(config.width); err != nil {
+			return err
+		}
+	}
+	if config.gravityCurve = getGravityCurve(strings.ToUpper(gravityName)); config.gravityCurve == nil {
+		return fmt.Errorf("unknown gravity curve %q", gravityName)
+	}
+	if config.timing = getTiming(strings.ToUpper(timingName)); config.timing == nil {
+		return fmt.Errorf("unknown timing %q", timingName)
+	}
+	if config.workers < 1 {
+		config.workers = 1
+	}
+
+	numImprovements := len(setupImprovements().catalog)
+	config.improvements = make([]int, numImprovements)
+	if improvementsList != "" {
+		levels := strings.Split(improvementsList, ",")
+		if len(levels) > numImprovements {
+			return fmt.Errorf("%d improvement levels given, only %d improvements exist", len(levels), numImprovements)
+		}
+		for i, level := range levels {
+			value, err := strconv.Atoi(strings.TrimSpace(level))
+			if err != nil {
+				return fmt.Errorf("improvement %d: %w", i, err)
+			}
+			config.improvements[i] = value
+		}
+	}
+
+	runs := simulate(config)
+	summary := summarize(config, runs)
+
+	fmt.Printf("%d runs, policy %s\n", summary.Runs, summary.Policy)
+	fmt.Printf("win rate: %.1f%% (timeouts %.1f%%)\n", 100*summary.WinRate, 100*summary.TimeoutRate)
+	fmt.Printf("average level reached: %.2f\n", summary.AverageLevel)
+	fmt.Printf("average score: %.0f, average coins: %.1f\n", summary.AverageScore, summary.AverageCoins)
+	for level, seconds := range summary.AverageLevelSeconds {
+		fmt.Printf("level %d: %.1fs\n", level+1, seconds)
+	}
+
+	if out == "" {
+		return nil
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if format == "json" {
+		return writeSimJSON(file, summary, runs)
+	}
+	return writeSimCSV(file, runs)
+}
+
+// simulate all the runs of a batch, in parallel
+func simulate(config simConfig) []simRun {
+	runs := make([]simRun, config.runs)
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < config.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for run := range next {
+				runs[run] = simulateRun(config, config.seed+int64(run))
+			}
+		}()
+	}
+
+	for run := range runs {
+		next <- run
+	}
+	close(next)
+	wg.Wait()
+
+	return runs
+}
+
+// simulate one run, following the same steps as the game
+func simulateRun(config simConfig, seed int64) (run simRun) {
+	run.Seed = seed
+	rng := rand.New(rand.NewSource(seed))
+
+	improv := setupImprovements()
+	for i := range improv.levels {
+		improv.levels[i] = min(config.improvements[i], len(improv.catalog[i].prices))
+	}
+	effects := improv.getEffects()
+	money := config.startMoney
+
+	balance := newBalance(config.numChoices, effects)
+	balance.rng = rand.New(rand.NewSource(rng.Int63()))
+	t := tetris{rng: rand.New(rand.NewSource(rng.Int63())), width: config.width, height: config.height, pieceSet: config.pieceSet, gravityCurve: config.gravityCurve, timing: config.timing, cascade: config.cascade}
+	t.init(0, balance, 0, 0, effects, effects.life)
+	b := newBot(defaultBotHeuristic)
+
+	for level := 0; ; level++ {
+		run.LevelReached = level
+		startPieces := t.pieces
+		frames := playBotLevel(&t, &b, level, balance.getGoalLines(), startPieces+config.maxPieces)
+		run.Lines += t.numLines
+		if t.dead {
+			break
+		}
+		if t.pieces-startPieces >= config.maxPieces {
+			run.TimedOut = true
+			break
+		}
+		run.LevelSeconds = append(run.LevelSeconds, float64(frames)/simFramesPerSecond)
+
+		if level+1 >= config.goalLevel {
+			run.Won = true
+			run.LevelReached = level + 1
+			break
+		}
+
+		balance.startDraft(level, money)
+		for end := false; !end; {
+			if !balance.inBoonDraft && len(balance.items) > 0 {
+				balance.choice = simChooseMalus(config.policy, balance, money, rng)
+				if balance.items[balance.choice] >= 0 {
+					run.Maluses = append(run.Maluses, balance.items[balance.choice])
+				}
+			} else if balance.inBoonDraft && config.policy == simPolicyRandom {
+				balance.choice = rng.Intn(len(balance.items))
+			}
+			end, _ = balance.selectItem(&money)
+		}
+
+		levelEffects := balance.applyBoons(effects)
+		t.init(level+1, balance, level+1, t.score, levelEffects, t.currentLife)
+		t.removeBottomLines(balance.getBombLines())
+	}
+
+	run.Score = t.score
+	run.Pieces = t.pieces
+	run.Multiplier = balance.getMultiplier()
+	m := moneyHandler{}
+	m.addScore(t.score, run.Multiplier)
+	run.Coins = m.money
+	run.balanceLevels = balance.levels
+
+	return
+}
+
+// position in the balancing carousel of the item chosen by a policy
+func simChooseMalus(policy string, b balancing, money int, rng *rand.Rand) int {
+	maluses := make([]int, 0, len(b.items))
+	for pos, item := range b.items {
+		if item == choiceSkip && policy == simPolicySkipper && money >= skipPrice {
+			return pos
+		}
+		if item >= 0 {
+			maluses = append(maluses, pos)
+		}
+	}
+
+	if len(maluses) == 0 {
+		return 0
+	}
+
+	switch policy {
+	case simPolicyFirst:
+		return maluses[0]
+	case simPolicySafe, simPolicyGreedy:
+		best := maluses[0]
+		for _, pos := range maluses[1:] {
+			reward, bestReward := malusRewards[b.items[pos]], malusRewards[b.items[best]]
+			if (policy == simPolicySafe && reward < bestReward) ||
+				(policy == simPolicyGreedy && reward > bestReward) {
+				best = pos
+			}
+		}
+		return best
+	}
+
+	return maluses[rng.Intn(len(maluses))]
+}
+
+// aggregate the results of a batch of runs
+func summarize(config simConfig, runs []simRun) (summary simSummary) {
+	summary.Runs = len(runs)
+	summary.Policy = config.policy
+	if len(runs) == 0 {
+		return
+	}
+
+	var levelRuns []int
+	for _, run := range runs {
+		if run.Won {
+			summary.WinRate++
+		}
+		if run.TimedOut {
+			summary.TimeoutRate++
+		}
+		summary.AverageLevel += float64(run.LevelReached)
+		summary.AverageCoins += float64(run.Coins)
+		summary.AverageScore += float64(run.Score)
+		for level, seconds := range run.LevelSeconds {
+			if level >= len(summary.AverageLevelSeconds) {
+				summary.AverageLevelSeconds = append(summary.AverageLevelSeconds, 0)
+				levelRuns = append(levelRuns, 0)
+			}
+			summary.AverageLevelSeconds[level] += seconds
+			levelRuns[level]++
+		}
+	}
+
+	numRuns := float64(len(runs))
+	summary.WinRate /= numRuns
+	summary.TimeoutRate /= numRuns
+	summary.AverageLevel /= numRuns
+	summary.AverageCoins /= numRuns
+	summary.AverageScore /= numRuns
+	for level := range summary.AverageLevelSeconds {
+		summary.AverageLevelSeconds[level] /= float64(levelRuns[level])
+	}
+
+	return
+}
+
+// write one line per run, with the time of each level separated by semicolons
+func writeSimCSV(w io.Writer, runs []simRun) error {
+	out := csv.NewWriter(w)
+
+	header := []string{"seed", "won", "level_reached", "timed_out", "score", "lines", "pieces", "coins", "multiplier"}
+	for malus := 0; malus < numBalances; malus++ {
+		header = append(header, fmt.Sprintf("malus_%d", malus))
+	}
+	header = append(header, "level_seconds")
+	if err := out.Write(header); err != nil {
+		return err
+	}
+
+	for _, run := range runs {
+		record := []string{
+			strconv.FormatInt(run.Seed, 10),
+			strconv.FormatBool(run.Won),
+			strconv.Itoa(run.LevelReached),
+			strconv.FormatBool(run.TimedOut),
+			strconv.Itoa(run.Score),
+			strconv.Itoa(run.Lines),
+			strconv.Itoa(run.Pieces),
+			strconv.Itoa(run.Coins),
+			strconv.Itoa(run.Multiplier),
+		}
+		for _, level := range run.balanceLevels {
+			record = append(record, strconv.Itoa(level))
+		}
+		seconds := make([]string, len(run.LevelSeconds))
+		for i, s := range run.LevelSeconds {
+			seconds[i] = strconv.FormatFloat(s, 'f', 2, 64)
+		}
+		record = append(record, strings.Join(seconds, ";"))
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// write the summary and all the runs
+func writeSimJSON(w io.Writer, summary simSummary, runs []simRun) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Summary simSummary `json:"summary"`
+		Runs    []simRun   `json:"runs"`
+	}{summary, runs})
+}
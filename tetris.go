@@ -19,25 +19,100 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
-	"image"
+	"fmt"
 	"image/color"
 	"math/rand"
+	"slices"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/loig/ebitenginegamejam2024/assets"
 )
 
-type tetrisLine = [gPlayAreaWidthInBlocks]int
-type tetrisGrid = [gPlayAreaHeightInBlocks + gInvisibleLines]tetrisLine
+// one line of the play area, holding the style of each square (noStyle if empty)
+type tetrisLine []int
+
+// the play area, from the invisible lines at the top to the bottom
+type tetrisGrid []tetrisLine
+
+func newTetrisGrid(width, height int) tetrisGrid {
+	grid := make(tetrisGrid, height)
+	for y := range grid {
+		grid[y] = make(tetrisLine, width)
+	}
+	return grid
+}
+
+// copy of the grid which does not share its lines with the original
+func (g tetrisGrid) clone() tetrisGrid {
+	grid := make(tetrisGrid, len(g))
+	for y, line := range g {
+		grid[y] = append(tetrisLine(nil), line...)
+	}
+	return grid
+}
+
+// columns left free when the shrink malus fills the others with walls,
+// more are kept when wider blocks than the tetrominoes can appear
+const minFreeColumns int = 4
+
+// check if a line has no empty square
+func (l tetrisLine) complete() bool {
+	for _, style := range l {
+		if style == noStyle {
+			return false
+		}
+	}
+	return true
+}
+
+// width of the grid in squares
+func (g tetrisGrid) width() int {
+	if len(g) == 0 {
+		return 0
+	}
+	return len(g[0])
+}
+
+// keys held when a block appears, for initial rotation and initial hold (IRS/IHS)
+type initialActions struct {
+	hold        bool
+	rotateLeft  bool
+	rotateRight bool
+}
 
 // Structure for one tetris game
 type tetris struct {
 	area                  tetrisGrid
+	width                 int           // number of columns, set before init at level 0 (gPlayAreaWidthInBlocks if not set)
+	height                int           // number of visible lines, set before init at level 0 (gPlayAreaHeightInBlocks if not set)
+	spawnX                int           // column where new blocks appear
+	obstacles             []tetrisBlock // blocks of other players that the current block cannot cross (co-op mode)
+	pieceSet              *pieceSet     // blocks given by the randomizer, the tetrominoes if nil
+	weirdLevel            int           // level of the weird pieces malus
+	mirrored              bool          // the play area is drawn mirrored and left/right are inverted
+	swapPeriod            int           // frames between two swaps of the rotation keys, 0 for no swap
+	swapFrame             int
+	rotationsSwapped      bool
+	slipperiness          int // squares a block slides after left/right is released
+	slideLeft             int // squares the current block still has to slide
+	slideDirection        int
+	slideFrame            int
+	wallColumns           int            // columns filled with walls, alternately on the right and left sides
+	specialChance         int            // chance in percent that a new block has a special square
+	coins                 int            // coins collected from coin squares, not taken by the caller yet
+	collectedCoins        int            // coins collected from coin squares since the start of the level
+	cursedHearts          int            // hearts lost to curse squares since the start of the run
+	cascade               bool           // groups of connected squares fall after line clears, set before init at level 0
+	chain                 int            // step of the chain of the lines being removed, 0 for the ones completed by the block
+	gravityCurve          *gravityCurve  // speeds of the blocks for each speed level, the classic curve if nil
+	timing                *timing        // line clear and spawn delays, the classic ones if nil
+	initialActions        initialActions // keys held by the player, set before each update
 	currentBlock          tetrisBlock
 	nextBlock             tetrisBlock
+	previews              []tetrisBlock // blocks coming after nextBlock
 	heldBlock             tetrisBlock
-	autoDownFrame         int
-	autoDownFrameLimit    int
+	gravity               gravity
+	gravityProgress       int // part of a row fallen, in 1/gravity.frames of a row
 	manualDownFrame       int
 	manualDownFrameLimit  int
 	lrMoveFrame           int
@@ -46,17 +121,21 @@ type tetris struct {
 	lrFirstMoveFrameLimit int
 	manualMoveAllowed     bool
 	numLines              int
+	pieces                int        // number of blocks placed since the start of the run
+	rng                   *rand.Rand // only for the blocks, so that their sequence does not depend on the garbage received
+	garbageRng            *rand.Rand // holes of the garbage lines
 	dropLenght            int
 	deathLines            int
+	pendingGarbage        int // garbage lines to add when the next block appears (versus mode)
 	// animation and lines removal handling
-	toCheck                          [2]int
-	toRemove                         [4]bool
-	toRemoveNum                      int
-	firstAvailable                   int
-	removeLineAnimationFrame         int
-	removeLineAnimationStep          int
-	removeLineAnimationStepNumFrames int
-	inAnimation                      bool
+	toCheck                  [2]int
+	toRemove                 [blockSize]bool
+	toRemoveNum              int
+	firstAvailable           int
+	removeLineAnimationFrame int
+	removeLineAnimationStep  int
+	spawnWait                int // frames left before the next block appears
+	inAnimation              bool
 	// invisible blocks handling
 	invisibleLevel int
 	invisibleStep  int
@@ -66,22 +145,65 @@ type tetris struct {
 	// improvements
 	betterRotation      bool
 	canHold             bool
+	holdUsed            bool // the current block went through the hold box, no more hold until it locks
+	showGhost           bool
 	life                int
 	currentLife         int
+	shields             int
+	undoLeft            int
+	undoAvailable       bool
+	undoState           tetrisSnapshot
 	dead                bool
 	deathAnimationFrame int
 }
 
-func (t *tetris) init(level int, balance balancing, speedLevel int, score int, betterRotation, canHold bool, life, currentLife int) {
+// state of a tetris game before the last block was locked
+type tetrisSnapshot struct {
+	area     tetrisGrid
+	block    tetrisBlock
+	next     tetrisBlock
+	previews []tetrisBlock
+	held     tetrisBlock
+	score    int
+	numLines int
+	// effects of the special squares cleared after the lock
+	collectedCoins int
+	life           int
+	currentLife    int
+	cursedHearts   int
+}
+
+func (t *tetris) init(level int, balance balancing, speedLevel int, score int, effects improvementEffects, currentLife int) {
 	if level == 0 {
-		t.area = tetrisGrid{}
-		t.currentBlock = getNewBlock(tetrisBlock{id: -1}, tetrisBlock{id: -1})
-		t.currentBlock.setInitialPosition()
-		t.nextBlock = getNewBlock(tetrisBlock{id: -1}, tetrisBlock{id: -1})
+		if t.rng == nil {
+			t.rng = newRandom()
+		}
+		if t.garbageRng == nil {
+			t.garbageRng = newRandom()
+		}
+		if t.width <= 0 {
+			t.width = gPlayAreaWidthInBlocks
+		}
+		if t.height <= 0 {
+			t.height = gPlayAreaHeightInBlocks
+		}
+		t.area = newTetrisGrid(t.width, t.height+gInvisibleLines)
+		// centered for the tetrominoes, with the box of the blocks inside the area when it fits
+		t.spawnX = max(0, min((t.width-4)/2, t.width-blockSize))
+		t.currentBlock = t.getNewBlock(tetrisBlock{id: -1}, tetrisBlock{id: -1})
+		t.currentBlock.setInitialPosition(t.spawnX)
+		t.nextBlock = t.getNewBlock(tetrisBlock{id: -1}, tetrisBlock{id: -1})
+		t.previews = nil
 		t.heldBlock = tetrisBlock{id: -1}
+		t.pieces = 0
+		t.cursedHearts = 0
 	}
-	t.autoDownFrame = 0
-	t.autoDownFrameLimit = gSpeeds[balance.getSpeedLevel(speedLevel)]
+	for len(t.previews) < effects.previews {
+		t.previews = append(t.previews, t.getFutureBlock())
+	}
+	t.previews = t.previews[:effects.previews]
+	t.gravityProgress = 0
+	t.gravity = t.gravityCurve.at(balance.getSpeedLevel(speedLevel))
 	t.manualDownFrame = 0
 	t.manualDownFrameLimit = 4
 	t.lrMoveFrame = 0
@@ -91,22 +213,45 @@ func (t *tetris) init(level int, balance balancing, speedLevel int, score int, b
 	t.manualMoveAllowed = true
 	t.numLines = 0
 	t.dropLenght = 0
-	t.deathLines = balance.getDeathLines()
+	t.pendingGarbage = 0
+	t.deathLines = balance.getDeathLines(t.height)
 	t.toCheck = [2]int{}
-	t.toRemove = [4]bool{}
+	t.toRemove = [blockSize]bool{}
 	t.toRemoveNum = 0
+	t.chain = 0
 	t.removeLineAnimationFrame = 0
 	t.removeLineAnimationStep = 0
-	t.removeLineAnimationStepNumFrames = 8
+	t.spawnWait = 0
+	if t.timing == nil {
+		t.timing = getTiming(classicTimingName)
+	}
 	t.invisibleFrame = 0
 	t.invisibleStep = maxLevelInvisibleBlocks
 	t.invisibleLevel = balance.getInvisibleBlocks()
+	t.weirdLevel = balance.getWeirdPieces()
+	t.mirrored = balance.getMirror()
+	t.swapPeriod = balance.getSwapRotationsPeriod()
+	t.swapFrame = 0
+	t.rotationsSwapped = false
+	t.slipperiness = balance.getSlipperiness()
+	t.slideLeft = 0
+	t.buildWalls(balance.getWallColumns())
+	t.specialChance = balance.getSpecialSquareChance()
 	t.score = score
 
-	t.betterRotation = betterRotation
-	t.canHold = canHold
-	t.life = life
+	t.betterRotation = effects.betterRotation
+	t.canHold = effects.canHold
+	t.holdUsed = false
+	t.showGhost = effects.showGhost
+	t.life = effects.life
+	if t.life > 0 {
+		t.life = max(0, t.life-t.cursedHearts)
+	}
+	t.collectedCoins = 0
 	t.currentLife = currentLife
+	t.shields = effects.shields
+	t.undoLeft = effects.undos
+	t.undoAvailable = false
 	t.dead = false
 	t.deathAnimationFrame = 0
 
@@ -114,6 +259,7 @@ func (t *tetris) init(level int, balance balancing, speedLevel int, score int, b
 }
 
 func (t *tetris) setUpNext() {
+	t.receiveGarbage()
 	t.lost()
 
 	if t.dead {
@@ -121,10 +267,12 @@ func (t *tetris) setUpNext() {
 		return
 	}
 
-	futureBlock := getNewBlock(t.currentBlock, t.nextBlock)
-	t.currentBlock = t.nextBlock
-	t.currentBlock.setInitialPosition()
-	t.nextBlock = futureBlock
+	t.currentBlock = t.pullNext()
+	t.currentBlock.setInitialPosition(t.spawnX)
+	t.pieces++
+	t.holdUsed = false
+	t.slideLeft = 0
+	t.applyInitialActions()
 
 	t.manualMoveAllowed = false
 
@@ -132,7 +280,264 @@ func (t *tetris) setUpNext() {
 	t.invisibleStep = maxLevelInvisibleBlocks
 }
 
-func (t *tetris) update(moveDownRequest, moveLeftRequest, moveRightRequest, holdRequest, rotateLeft, rotateRight bool, level int) (playSounds [assets.NumSounds]bool) {
+// check if the current block can go in the hold box: it can only be done once per block
+func (t tetris) holdAvailable() bool {
+	return t.canHold && !t.holdUsed
+}
+
+// put the current block in the hold box and take the held one,
+// or the next one if none is held, if it fits at the same place
+func (t *tetris) hold(grid tetrisGrid) bool {
+	if !canReplace(t.currentBlock.x, t.currentBlock.y, t.heldBlock, t.nextBlock, grid) {
+		return false
+	}
+	t.holdUsed = true
+	t.heldBlock, t.currentBlock = t.currentBlock, t.heldBlock
+	if t.currentBlock.id < 0 {
+		t.currentBlock = t.pullNext()
+	}
+	t.currentBlock.x = t.heldBlock.x
+	t.currentBlock.y = t.heldBlock.y
+	t.heldBlock.x = 0
+	t.heldBlock.y = 0
+	return true
+}
+
+// initial hold and initial rotation (IHS/IRS): the actions held when a block
+// appears are done before its first fall, if the block fits in the area
+func (t *tetris) applyInitialActions() {
+	grid := t.collisionGrid()
+	if t.holdAvailable() && t.initialActions.hold {
+		t.hold(grid)
+	}
+	if t.initialActions.rotateLeft && !t.initialActions.rotateRight {
+		t.currentBlock.rotateLeft(grid)
+	}
+	if t.initialActions.rotateRight && !t.initialActions.rotateLeft {
+		t.currentBlock.rotateRight(grid)
+	}
+}
+
+// make the next block appear, after the spawn delay if any
+func (t *tetris) spawnNext() {
+	if t.timing.spawnDelay > 0 {
+		t.spawnWait = t.timing.spawnDelay
+		t.inAnimation = true
+		return
+	}
+	t.setUpNext()
+}
+
+// count the lines being removed in the score,
+// multiplied by the step of the chain with cascade gravity
+func (t *tetris) scoreLines(level int) {
+	switch t.toRemoveNum {
+	case 1:
+		t.score += 40 * (level + 1) * (t.chain + 1)
+	case 2:
+		t.score += 100 * (level + 1) * (t.chain + 1)
+	case 3:
+		t.score += 300 * (level + 1) * (t.chain + 1)
+	case 4:
+		t.score += 1200 * (level + 1) * (t.chain + 1)
+	case 5: // only with pentominoes
+		t.score += 2000 * (level + 1) * (t.chain + 1)
+	}
+	t.numLines += t.toRemoveNum
+}
+
+// remove the complete lines from the area
+func (t *tetris) clearLines() (playSounds [assets.NumSounds]bool) {
+	playSounds = t.triggerSpecials()
+	t.removeLines()
+	t.toRemove = [blockSize]bool{}
+	t.toRemoveNum = 0
+	t.toCheck = [2]int{}
+	return
+}
+
+// apply the effects of the special squares of the lines being removed:
+// bombs clear the 3x3 squares around them (walls excepted), coins are
+// collected and curses cost a heart, the special squares cleared by a
+// bomb take effect too
+func (t *tetris) triggerSpecials() (playSounds [assets.NumSounds]bool) {
+	var triggered [][2]int
+	for y := t.toCheck[0]; y <= t.toCheck[1]; y++ {
+		if !t.toRemove[y-t.toCheck[0]] {
+			continue
+		}
+		for x := range t.area[y] {
+			triggered = append(triggered, [2]int{x, y})
+		}
+	}
+
+	for len(triggered) > 0 {
+		x, y := triggered[0][0], triggered[0][1]
+		triggered = triggered[1:]
+		style := t.area[y][x]
+		if !slices.Contains(specialStyles, style) {
+			continue
+		}
+		t.area[y][x] = noStyle
+
+		switch style {
+		case bombStyle:
+			playSounds[assets.SoundBombID] = true
+			for yy := max(0, y-1); yy <= min(len(t.area)-1, y+1); yy++ {
+				for xx := max(0, x-1); xx <= min(t.area.width()-1, x+1); xx++ {
+					switch {
+					case slices.Contains(specialStyles, t.area[yy][xx]):
+						triggered = append(triggered, [2]int{xx, yy})
+					case t.area[yy][xx] != wallStyle:
+						t.area[yy][xx] = noStyle
+					}
+				}
+			}
+		case coinStyle:
+			playSounds[assets.SoundCoinID] = true
+			t.coins++
+			t.collectedCoins++
+		case curseStyle:
+			playSounds[assets.SoundMenuNoID] = true
+			if t.life > 0 {
+				t.life--
+				t.cursedHearts++
+			}
+		}
+	}
+	return
+}
+
+// give the coins collected since the last call, negative when
+// coins were given back by an undo
+func (t *tetris) takeCoins() (coins int) {
+	coins, t.coins = t.coins, 0
+	return
+}
+
+// check if the current block is in the area, not locked yet and not waiting to appear
+func (t tetris) blockInPlay() bool {
+	return t.removeLineAnimationStep == 0 && t.spawnWait == 0
+}
+
+// grid used for moving the current block: the area with the blocks of
+// the other players written in it, if any
+// a block overlapping the block of another player (when appearing on it)
+// goes through it until they are apart
+func (t tetris) collisionGrid() tetrisGrid {
+	if len(t.obstacles) == 0 {
+		return t.area
+	}
+	grid := t.area.clone()
+	for _, block := range t.obstacles {
+		if block.id >= 0 && block.isInValidPosition(grid) {
+			block.writeInGrid(grid)
+		}
+	}
+	if !t.currentBlock.isInValidPosition(grid) {
+		return t.area
+	}
+	return grid
+}
+
+// check if the current block is not visible because of the invisible blocks malus
+func (t tetris) currentBlockHidden() bool {
+	return t.invisibleStep <= t.invisibleLevel && t.currentBlock.y >= gInvisibleLines
+}
+
+// get a new block, taking into account the last blocks of the queue
+func (t tetris) getFutureBlock() tetrisBlock {
+	beforeLast, last := t.currentBlock, t.nextBlock
+	if len(t.previews) > 0 {
+		last = t.previews[len(t.previews)-1]
+		beforeLast = t.nextBlock
+		if len(t.previews) > 1 {
+			beforeLast = t.previews[len(t.previews)-2]
+		}
+	}
+	block := t.getNewBlock(beforeLast, last)
+	if t.specialChance > 0 && t.rng.Intn(100) < t.specialChance {
+		block.setSpecial(specialStyles[t.rng.Intn(len(specialStyles))], t.rng.Intn(block.numSquares()))
+	}
+	return block
+}
+
+// draw a block from the piece set, or a weird piece because of the malus
+func (t tetris) getNewBlock(current, next tetrisBlock) tetrisBlock {
+	if t.weirdLevel > 0 && t.rng.Intn(100) < t.weirdLevel*weirdPieceChance {
+		if weird := getPieceSet(weirdPieceSetName); weird != nil && weird.width() <= t.width {
+			return weird.getNewBlock(t.rng, next)
+		}
+	}
+	if t.pieceSet != nil {
+		return t.pieceSet.getNewBlock(t.rng, next)
+	}
+	return getNewBlock(t.rng, current, next)
+}
+
+// take the next block and refill the queue
+func (t *tetris) pullNext() (block tetrisBlock) {
+	block = t.nextBlock
+	futureBlock := t.getFutureBlock()
+	if len(t.previews) > 0 {
+		t.nextBlock = t.previews[0]
+		t.previews = append(t.previews[1:], futureBlock)
+		return
+	}
+	t.nextBlock = futureBlock
+	return
+}
+
+// save the state of the game before locking the current block
+func (t *tetris) saveUndoState() {
+	if t.undoLeft <= 0 {
+		return
+	}
+	t.undoState = tetrisSnapshot{
+		area:     t.area.clone(),
+		block:    t.currentBlock,
+		next:     t.nextBlock,
+		previews: append([]tetrisBlock(nil), t.previews...),
+		held:     t.heldBlock,
+		score:    t.score,
+		numLines: t.numLines,
+
+		collectedCoins: t.collectedCoins,
+		life:           t.life,
+		currentLife:    t.currentLife,
+		cursedHearts:   t.cursedHearts,
+	}
+	t.undoAvailable = true
+}
+
+// go back to the state before the last block was locked
+func (t *tetris) undo() bool {
+	if !t.undoAvailable || t.undoLeft <= 0 {
+		return false
+	}
+	t.area = t.undoState.area
+	t.currentBlock = t.undoState.block
+	t.currentBlock.r = 0
+	t.currentBlock.setInitialPosition(t.spawnX)
+	t.nextBlock = t.undoState.next
+	t.previews = t.undoState.previews
+	t.heldBlock = t.undoState.held
+	t.score = t.undoState.score
+	t.numLines = t.undoState.numLines
+	// coins already taken by the caller are given back
+	t.coins -= t.collectedCoins - t.undoState.collectedCoins
+	t.collectedCoins = t.undoState.collectedCoins
+	t.life = t.undoState.life
+	t.currentLife = t.undoState.currentLife
+	t.cursedHearts = t.undoState.cursedHearts
+	t.undoAvailable = false
+	t.holdUsed = false
+	t.undoLeft--
+	t.manualMoveAllowed = false
+	return true
+}
+
+func (t *tetris) update(moveDownRequest, moveLeftRequest, moveRightRequest, holdRequest, rotateLeft, rotateRight, rotateHalf, undoRequest bool, level int) (playSounds [assets.NumSounds]bool) {
 
 	if t.dead {
 		playSounds[assets.SoundDeathID] = t.deathAnimationFrame == 0
@@ -143,59 +548,80 @@ func (t *tetris) update(moveDownRequest, moveLeftRequest, moveRightRequest, hold
 		return
 	}
 
+	// maluses on the controls, rotation keys are swapped regularly,
+	// remapped first for the initial actions of the blocks appearing below
+	if t.swapPeriod > 0 {
+		t.swapFrame++
+		if t.swapFrame >= t.swapPeriod {
+			t.swapFrame = 0
+			t.rotationsSwapped = !t.rotationsSwapped
+		}
+	}
+	if t.mirrored {
+		moveLeftRequest, moveRightRequest = moveRightRequest, moveLeftRequest
+	}
+	if t.mirrored != t.rotationsSwapped {
+		rotateLeft, rotateRight = rotateRight, rotateLeft
+		t.initialActions.rotateLeft, t.initialActions.rotateRight = t.initialActions.rotateRight, t.initialActions.rotateLeft
+	}
+
 	if t.removeLineAnimationStep > 0 {
 
+		// the steps of the animation are spread over the line clear delay
 		t.removeLineAnimationFrame++
-		if t.removeLineAnimationFrame >= t.removeLineAnimationStepNumFrames {
-			t.removeLineAnimationStep++
-			t.removeLineAnimationFrame = 0
-		}
-
-		if t.removeLineAnimationStep == 4 && t.removeLineAnimationFrame <= 0 {
-			switch t.toRemoveNum {
-			case 1:
-				t.score += 40 * (level + 1)
-			case 2:
-				t.score += 100 * (level + 1)
-			case 3:
-				t.score += 300 * (level + 1)
-			case 4:
-				t.score += 1200 * (level + 1)
-			}
-			t.numLines += t.toRemoveNum
+		step := 1 + t.removeLineAnimationFrame*(removeLineAnimationSteps-1)/t.timing.lineClear
+
+		if step >= 4 && t.removeLineAnimationStep < 4 {
+			t.scoreLines(level)
 		}
+		t.removeLineAnimationStep = step
 
-		if t.removeLineAnimationStep < 8 {
+		if t.removeLineAnimationStep < removeLineAnimationSteps {
 			return
 		}
 
 		t.removeLineAnimationStep = 0
+		t.removeLineAnimationFrame = 0
 
 		// lines removal animation and effects
+		playSounds = t.clearLines()
 		playSounds[assets.SoundLinesFallingID] = true
-		t.removeLines()
 
-		t.toRemove = [4]bool{}
-		t.toRemoveNum = 0
-		t.toCheck = [2]int{}
+		// the falling squares completed other lines
+		if t.nextChain() {
+			playSounds[assets.SoundLinesVanishingID] = true
+			t.removeLineAnimationStep = 1
+			return
+		}
 		t.inAnimation = false
 
-		t.setUpNext()
+		t.spawnNext()
+
+		return
+	}
 
+	if t.spawnWait > 0 {
+		t.spawnWait--
+		if t.spawnWait == 0 {
+			t.inAnimation = false
+			t.setUpNext()
+		}
 		return
 	}
 
+	if undoRequest {
+		if t.undo() {
+			playSounds[assets.SoundLinesFallingID] = true
+			return
+		}
+		playSounds[assets.SoundMenuNoID] = true
+	}
+
+	grid := t.collisionGrid()
+
 	if t.canHold && holdRequest {
-		if canReplace(t.currentBlock.x, t.currentBlock.y, t.heldBlock, t.nextBlock, t.area) {
-			t.heldBlock, t.currentBlock = t.currentBlock, t.heldBlock
-			if t.currentBlock.id < 0 {
-				t.currentBlock = t.nextBlock
-				t.nextBlock = getNewBlock(t.heldBlock, t.currentBlock)
-			}
-			t.currentBlock.x = t.heldBlock.x
-			t.currentBlock.y = t.heldBlock.y
-			t.heldBlock.x = 0
-			t.heldBlock.y = 0
+		if !t.holdAvailable() || !t.hold(grid) {
+			playSounds[assets.SoundMenuNoID] = true
 		}
 	}
 
@@ -211,16 +637,23 @@ func (t *tetris) update(moveDownRequest, moveLeftRequest, moveRightRequest, hold
 	effectiveRotation := false
 
 	if rotateLeft && !rotateRight {
-		effectiveRotation = t.currentBlock.rotateLeft(t.area)
+		effectiveRotation = t.currentBlock.rotateLeft(grid)
 	}
 
 	if rotateRight && !rotateLeft {
-		effectiveRotation = t.currentBlock.rotateRight(t.area)
+		effectiveRotation = t.currentBlock.rotateRight(grid)
+	}
+
+	// half turns get kicks with the better rotation improvement
+	halfTurn := false
+	if rotateHalf && !rotateLeft && !rotateRight {
+		halfTurn = t.currentBlock.rotateHalf(grid, t.betterRotation)
 	}
 
 	playSounds[assets.SoundRotationID] = effectiveRotation
-	if effectiveRotation && t.betterRotation {
-		t.autoDownFrame = 0
+	playSounds[assets.SoundRotationHalfID] = halfTurn
+	if (effectiveRotation || halfTurn) && t.betterRotation {
+		t.gravityProgress = 0
 	}
 
 	mayAllowManualMoves := false
@@ -243,6 +676,7 @@ func (t *tetris) update(moveDownRequest, moveLeftRequest, moveRightRequest, hold
 	if !t.manualMoveAllowed {
 		xMove = 0
 	}
+	direction := xMove
 
 	if xMove != 0 {
 		if t.lrMoveFrame > 0 || (t.lrFirstMoveFrame > 0 && t.lrFirstMoveFrame < t.lrFirstMoveFrameLimit) {
@@ -257,15 +691,23 @@ func (t *tetris) update(moveDownRequest, moveLeftRequest, moveRightRequest, hold
 		}
 	}
 
-	// automatic down movement of blocks handling
-	autoDown := false
-	t.autoDownFrame++
-
-	if t.autoDownFrame >= t.autoDownFrameLimit {
-		autoDown = true
-		t.autoDownFrame = 0
+	// slippery malus: the block keeps sliding after left/right is released
+	if direction != 0 {
+		t.slideDirection = direction
+		t.slideLeft = t.slipperiness
+		t.slideFrame = 0
+	} else if t.slideLeft > 0 {
+		t.slideFrame++
+		if t.slideFrame >= t.lrMoveFrameLimit {
+			t.slideFrame = 0
+			t.slideLeft--
+			xMove = t.slideDirection
+		}
 	}
 
+	// automatic down movement of blocks handling
+	autoDownRows := t.gravity.fall(&t.gravityProgress)
+
 	// manual down movement of blocks handling
 	manualDown := false
 
@@ -287,26 +729,53 @@ func (t *tetris) update(moveDownRequest, moveLeftRequest, moveRightRequest, hold
 		t.dropLenght++
 	}
 
+	downRows := autoDownRows
+	if manualDown && downRows == 0 {
+		downRows = 1
+	}
+
 	// update position according to movements requests
 	var stuck bool
-	stuck, playSounds[assets.SoundLeftRightID] = t.currentBlock.updatePosition(xMove, autoDown || manualDown, t.area)
+	stuck, playSounds[assets.SoundLeftRightID] = t.currentBlock.updatePosition(xMove, downRows, grid)
+	if stuck && len(t.obstacles) > 0 {
+		// a block resting on the block of another player waits for it to move
+		below := t.currentBlock
+		stuck = below.moveDown(t.area)
+	}
 	if stuck {
 		playSounds[assets.SoundTouchGroundID] = true
 
-		t.toCheck = t.currentBlock.writeInGrid(&t.area)
+		// a key pressed for the block that locks is not used again by the next one
+		t.initialActions.hold = t.initialActions.hold && !holdRequest
+		t.initialActions.rotateLeft = t.initialActions.rotateLeft && !rotateLeft
+		t.initialActions.rotateRight = t.initialActions.rotateRight && !rotateRight
+
+		t.saveUndoState()
+		t.toCheck = t.currentBlock.writeInGrid(t.area)
+		t.chain = 0
 
 		t.score += t.dropLenght
 
 		t.toRemoveNum, t.firstAvailable, t.toRemove = t.checkLines()
 
 		if t.toRemoveNum > 0 {
-			t.removeLineAnimationStep = 1
-			t.inAnimation = true
 			playSounds[assets.SoundLinesVanishingID] = true
-			return
+			if t.timing.lineClear > 0 {
+				t.removeLineAnimationStep = 1
+				t.inAnimation = true
+				return
+			}
+			// no animation, the lines vanish at once
+			for cleared := true; cleared; cleared = t.nextChain() {
+				t.scoreLines(level)
+				for sound, play := range t.clearLines() {
+					playSounds[sound] = playSounds[sound] || play
+				}
+			}
+			playSounds[assets.SoundLinesFallingID] = true
 		}
 
-		t.setUpNext()
+		t.spawnNext()
 	}
 
 	return
@@ -314,7 +783,7 @@ func (t *tetris) update(moveDownRequest, moveLeftRequest, moveRightRequest, hold
 
 // check if the lines in toCheck are complete
 // if so, remove them and update the grid
-func (t tetris) checkLines() (toRemoveNum int, firstAvailable int, toRemove [4]bool) {
+func (t tetris) checkLines() (toRemoveNum int, firstAvailable int, toRemove [blockSize]bool) {
 
 	count := -1
 	firstAvailable = t.toCheck[0] - 1
@@ -336,8 +805,78 @@ CheckLoop:
 	return
 }
 
+// check if a column is filled with walls by the shrink malus
+func (t tetris) isWallColumn(x int) bool {
+	return x < t.wallColumns/2 || x >= t.area.width()-(t.wallColumns+1)/2
+}
+
+// an empty line, with walls in the columns filled by the shrink malus
+func (t tetris) emptyLine() tetrisLine {
+	line := make(tetrisLine, t.area.width())
+	for x := range line {
+		if t.isWallColumn(x) {
+			line[x] = wallStyle
+		}
+	}
+	return line
+}
+
+// fill columns on the sides of the area with walls, the lines that
+// are completed by the walls are removed at once, without scoring
+func (t *tetris) buildWalls(columns int) {
+	t.wallColumns = max(0, min(columns, t.area.width()-max(minFreeColumns, t.widestBlock())))
+	for _, line := range t.area {
+		for x := range line {
+			if t.isWallColumn(x) {
+				line[x] = wallStyle
+			}
+		}
+	}
+
+	y := len(t.area) - 1
+	for line := len(t.area) - 1; line >= 0; line-- {
+		if !t.area[line].complete() {
+			t.area[y] = t.area[line]
+			y--
+		}
+	}
+	for ; y >= 0; y-- {
+		t.area[y] = t.emptyLine()
+	}
+
+	// the blocks appear between the walls
+	left, right := t.wallColumns/2, t.area.width()-(t.wallColumns+1)/2
+	t.spawnX = max(left, min(t.spawnX, right-t.widestBlock()))
+	if !t.currentBlock.isInValidPosition(t.area) {
+		t.currentBlock.setInitialPosition(t.spawnX)
+	}
+}
+
+// number of columns needed by the widest block that can appear
+func (t tetris) widestBlock() (width int) {
+	width = minFreeColumns // the tetrominoes
+	if t.pieceSet != nil {
+		width = t.pieceSet.width()
+	}
+	if weird := getPieceSet(weirdPieceSetName); t.weirdLevel > 0 && weird != nil && weird.width() <= t.width {
+		width = max(width, weird.width())
+	}
+	return
+}
+
 func (t *tetris) removeLines() {
 
+	// with cascade gravity the lines are emptied and the squares above fall by groups
+	if t.cascade {
+		for y := t.toCheck[0]; y <= t.toCheck[1]; y++ {
+			if t.toRemove[y-t.toCheck[0]] {
+				t.area[y] = t.emptyLine()
+			}
+		}
+		t.settle()
+		return
+	}
+
 	// remove them from the grid from bottom to top
 
 	// in the removal zone
@@ -349,7 +888,7 @@ func (t *tetris) removeLines() {
 				t.firstAvailable--
 			}
 		} else {
-			t.area[y] = tetrisLine{}
+			t.area[y] = t.emptyLine()
 		}
 	}
 
@@ -359,21 +898,63 @@ func (t *tetris) removeLines() {
 			t.area[y] = t.area[t.firstAvailable]
 			t.firstAvailable--
 		} else {
-			t.area[y] = tetrisLine{}
+			t.area[y] = t.emptyLine()
 		}
 	}
 
 }
 
+// remove lines at the bottom of the grid, making the lines above fall
+func (t *tetris) removeBottomLines(numLines int) {
+	for ; numLines > 0; numLines-- {
+		for y := len(t.area) - 1; y > 0; y-- {
+			t.area[y] = t.area[y-1]
+		}
+		t.area[0] = t.emptyLine()
+	}
+}
+
+// add the pending garbage lines at the bottom of the area,
+// with a hole at the same random position in all of them
+func (t *tetris) receiveGarbage() {
+	if t.pendingGarbage <= 0 {
+		return
+	}
+
+	hole := t.wallColumns/2 + t.garbageRng.Intn(t.area.width()-t.wallColumns)
+	for ; t.pendingGarbage > 0; t.pendingGarbage-- {
+		for y := 0; y < len(t.area)-1; y++ {
+			t.area[y] = t.area[y+1]
+		}
+		line := t.emptyLine()
+		for x, style := range line {
+			if style == noStyle {
+				line[x] = garbageStyle
+			}
+		}
+		line[hole] = noStyle
+		t.area[len(t.area)-1] = line
+	}
+}
+
 // check if there is anything in the above area
 // which would mean that the game is lost
+// if a shield is available it is used to clear this area instead
 func (t *tetris) lost() {
 	t.currentLife = t.life
 	for _, line := range t.area[:gInvisibleLines+t.deathLines] {
 		for _, v := range line {
-			if v != 0 {
+			if v != noStyle && v != wallStyle {
 				t.currentLife--
 				if t.currentLife < 0 {
+					if t.shields > 0 {
+						t.shields--
+						t.currentLife = t.life
+						for y := 0; y < gInvisibleLines+t.deathLines; y++ {
+							t.area[y] = t.emptyLine()
+						}
+						return
+					}
 					t.dead = true
 					return
 				}
@@ -382,10 +963,10 @@ func (t *tetris) lost() {
 	}
 }
 
-func getNewBlock(current, next tetrisBlock) (block tetrisBlock) {
+func getNewBlock(rng *rand.Rand, current, next tetrisBlock) (block tetrisBlock) {
 
 	getRandomBlock := func() tetrisBlock {
-		switch rand.Intn(7) {
+		switch rng.Intn(7) {
 		case 0:
 			return getIBlock()
 		case 1:
@@ -424,24 +1005,31 @@ func getNewBlock(current, next tetrisBlock) (block tetrisBlock) {
 
 }
 
-func (t tetris) drawHold(screen *ebiten.Image, gray uint8) {
+// xInfo is the left side of the info panel in pixels
+func (t tetris) drawHold(screen *ebiten.Image, gray uint8, xInfo int) {
 
-	x := gWidth - 3*gHoldSide/4 - gPlayAreaSide
+	x := xInfo + gInfoPanelWidth - 3*gHoldSide/4 - gPlayAreaSide
 	y := gHeight - gNextBoxSide - gHoldSide/2 + 10
 
+	// greyed out when the current block already went through it
+	if !t.holdAvailable() {
+		gray = uint8(int(gray) * 100 / 255)
+	}
+
 	options := ebiten.DrawImageOptions{}
 	options.ColorScale.ScaleWithColor(color.Gray{gray})
 	options.GeoM.Translate(float64(x), float64(y))
 	screen.DrawImage(assets.ImageHold, &options)
 
-	t.heldBlock.draw(screen, gray, x+gSquareSideSize/2, y+gSquareSideSize/2, 0.5)
+	t.heldBlock.draw(screen, gray, x+gSquareSideSize/2, y+gSquareSideSize/2, 0.5*t.heldBlock.boxScale())
 
 }
 
-func (t tetris) drawLife(screen *ebiten.Image, gray uint8) {
+// xInfo is the left side of the info panel in pixels
+func (t tetris) drawLife(screen *ebiten.Image, gray uint8, xInfo int) {
 
 	if t.life > 0 {
-		x := gPlayAreaSide + gPlayAreaWidth + gPlayAreaSide + gInfoLeftSide + (gInfoWidth-gHeartWidth*t.life)/2
+		x := xInfo + gInfoLeftSide + (gInfoWidth-gHeartWidth*t.life)/2
 		y := gYLevelFromTop - 2*gHeartWidth - 20
 
 		options := ebiten.DrawImageOptions{}
@@ -459,34 +1047,87 @@ func (t tetris) drawLife(screen *ebiten.Image, gray uint8) {
 
 }
 
+// draw the game with the info panel at the right of the play area
 func (t tetris) draw(screen *ebiten.Image, gray uint8) {
+	t.drawInfo(screen, gray, 2*gPlayAreaSide+t.area.width()*gSquareSideSize)
+	t.drawArea(screen, gray, gPlayAreaSide)
+}
 
-	t.drawLife(screen, gray)
+// draw the life, next blocks and held block, xInfo is the left side of the info panel in pixels
+func (t tetris) drawInfo(screen *ebiten.Image, gray uint8, xInfo int) {
 
-	xNextOrigin := gPlayAreaSide + gPlayAreaWidth + gPlayAreaSide + gInfoLeftSide + gNextMargin
+	t.drawLife(screen, gray, xInfo)
+
+	xNextOrigin := xInfo + gInfoLeftSide + gNextMargin
 	yNextOrigin := gInfoTop + gInfoSmallBoxHeight + gScoreToLevel + gInfoBoxHeight + gLevelToLines + gInfoBoxHeight + gLinesToNext + gNextMargin
 
-	t.nextBlock.draw(screen, gray, xNextOrigin, yNextOrigin, 1)
+	if len(t.previews) > 0 {
+		t.nextBlock.draw(screen, gray, xNextOrigin, yNextOrigin, 0.6*t.nextBlock.boxScale())
+		xPreview := xNextOrigin + 5*gNextBoxSide/10
+		for i, block := range t.previews {
+			block.draw(screen, gray, xPreview, yNextOrigin+i*3*gNextBoxSide/10, 0.35*block.boxScale())
+		}
+	} else {
+		t.nextBlock.draw(screen, gray, xNextOrigin, yNextOrigin, t.nextBlock.boxScale())
+	}
 
 	if t.canHold {
-		t.drawHold(screen, gray)
+		t.drawHold(screen, gray, xInfo)
 	}
+}
+
+// draw the current block and the grid, xOrigin is the left side of the grid in pixels
+func (t tetris) drawArea(screen *ebiten.Image, gray uint8, xOrigin int) {
 
-	xOrigin := gPlayAreaSide
 	yOrigin := gSquareSideSize * -gInvisibleLines
 
-	if t.removeLineAnimationStep == 0 {
-		if t.invisibleStep > t.invisibleLevel || t.currentBlock.y < gInvisibleLines {
-			t.currentBlock.draw(screen, gray, xOrigin, yOrigin, 1)
+	if t.blockInPlay() {
+		t.drawCurrentBlock(screen, gray, xOrigin, yOrigin)
+	}
+
+	t.drawGrid(screen, gray, xOrigin, yOrigin)
+
+	if t.rotationsSwapped {
+		drawTextCentered(screen, "SWAPPED", xOrigin+t.area.width()*gSquareSideSize/2, 3*gSquareSideSize/2, gTextScale, scaleColor(gMalusColor, gray))
+	}
+
+	// steps of a chain of clears with cascade gravity, on the lines being removed
+	if t.chain > 0 && t.removeLineAnimationStep > 0 {
+		y := yOrigin + (t.toCheck[0]+t.toCheck[1]+1)*gSquareSideSize/2
+		drawTextCentered(screen, fmt.Sprintf("CHAIN X%d", t.chain+1), xOrigin+t.area.width()*gSquareSideSize/2, y, gTextScale, scaleColor(gTextLightColor, gray))
+	}
+}
+
+// draw the current block and its ghost, xOrigin and yOrigin in pixels
+func (t tetris) drawCurrentBlock(screen *ebiten.Image, gray uint8, xOrigin, yOrigin int) {
+	if !t.currentBlockHidden() {
+		if t.showGhost {
+			ghost := t.currentBlock
+			grid := t.collisionGrid()
+			for !ghost.moveDown(grid) {
+			}
+			if t.mirrored {
+				ghost = ghost.mirrored(t.area.width())
+			}
+			ghost.drawWithAlpha(screen, gray, xOrigin, yOrigin, 1, 0.3)
+		}
+		block := t.currentBlock
+		if t.mirrored {
+			block = block.mirrored(t.area.width())
 		}
+		block.draw(screen, gray, xOrigin, yOrigin, 1)
 	}
+}
+
+// draw the squares of the grid, with the lines removal animation, xOrigin and yOrigin in pixels
+func (t tetris) drawGrid(screen *ebiten.Image, gray uint8, xOrigin, yOrigin int) {
 
 	for y, line := range t.area {
 		for x, style := range line {
 			if style != noStyle {
 
-				// removal animation
-				if t.removeLineAnimationStep%2 == 1 {
+				// removal animation, walls stay in place
+				if t.removeLineAnimationStep%2 == 1 && style != wallStyle {
 					if y >= t.toCheck[0] && y <= t.toCheck[1] &&
 						t.toRemove[y-t.toCheck[0]] {
 						if t.removeLineAnimationStep == 7 {
@@ -496,10 +1137,10 @@ func (t tetris) draw(screen *ebiten.Image, gray uint8) {
 					}
 				}
 
-				options := ebiten.DrawImageOptions{}
-				options.ColorScale.ScaleWithColor(color.Gray{gray})
-				options.GeoM.Translate(float64(xOrigin+x*gSquareSideSize), float64(yOrigin+y*gSquareSideSize))
-				screen.DrawImage(assets.ImageSquares.SubImage(image.Rect((style-1)*gSquareSideSize, 0, style*gSquareSideSize, gSquareSideSize)).(*ebiten.Image), &options)
+				if t.mirrored {
+					x = len(line) - 1 - x
+				}
+				drawSquare(screen, style, float64(xOrigin+x*gSquareSideSize), float64(yOrigin+y*gSquareSideSize), 1, gray, 1)
 			}
 		}
 	}
@@ -26,19 +26,120 @@ import (
 	"github.com/loig/ebitenginegamejam2024/assets"
 )
 
+const blockSize int = 5 // side of the square holding a block, in squares
+
 type tetrisBlock struct {
-	x, y   int           // position of upper left corner in squares
-	r      int           // rotation state id
-	states [4][4][4]bool // possible rotation states of the block
-	style  int           // style of the block (for drawing)
-	id     int8          // identifier of the block for randomisation
+	x, y      int                           // position of upper left corner in squares
+	r         int                           // rotation state id
+	states    [4][blockSize][blockSize]bool // possible rotation states of the block
+	style     int                           // style of the block (for drawing)
+	id        int8                          // identifier of the block for randomisation
+	special   int                           // style of the special square of the block, noStyle if none
+	specialAt [4][2]int                     // position of the special square in each rotation state
 }
 
-func (t *tetrisBlock) setInitialPosition() {
-	t.x = 3
+func (t *tetrisBlock) setInitialPosition(x int) {
+	t.x = x
 	t.y = 1
 }
 
+// number of squares of the side of the smallest square containing the
+// current rotation state, starting from the upper left corner
+func (t tetrisBlock) extent() (size int) {
+	for y, line := range t.states[t.r] {
+		for x, square := range line {
+			if square {
+				size = max(size, x+1, y+1)
+			}
+		}
+	}
+	return
+}
+
+// scaling for drawing the block in boxes made for the tetrominoes
+func (t tetrisBlock) boxScale() float64 {
+	return min(1, 4/float64(t.extent()))
+}
+
+// number of squares of the block
+func (t tetrisBlock) numSquares() (squares int) {
+	for _, line := range t.states[0] {
+		for _, square := range line {
+			if square {
+				squares++
+			}
+		}
+	}
+	return
+}
+
+// position of the square of a given rank, in reading order, in a rotation state
+func squareOfRank(state [blockSize][blockSize]bool, rank int) [2]int {
+	for y, line := range state {
+		for x, square := range line {
+			if square {
+				if rank == 0 {
+					return [2]int{x, y}
+				}
+				rank--
+			}
+		}
+	}
+	return [2]int{}
+}
+
+// rank, in reading order, of the square at a given position in a rotation state
+func rankOfSquare(state [blockSize][blockSize]bool, position [2]int) (rank int) {
+	for y := 0; y < position[1]; y++ {
+		for _, square := range state[y] {
+			if square {
+				rank++
+			}
+		}
+	}
+	for _, square := range state[position[1]][:position[0]] {
+		if square {
+			rank++
+		}
+	}
+	return
+}
+
+// make the square of a given rank in the first rotation state special, it
+// is followed through the rotations when they turn the block around the
+// center of its box and keeps its rank in the rotation state otherwise
+func (t *tetrisBlock) setSpecial(style, rank int) {
+	t.special = style
+	side := 0
+	for r := range t.states {
+		for y, line := range t.states[r] {
+			for x, square := range line {
+				if square {
+					side = max(side, x+1, y+1)
+				}
+			}
+		}
+	}
+	t.specialAt[0] = squareOfRank(t.states[0], rank)
+	for r := 1; r < len(t.states); r++ {
+		previous := t.specialAt[r-1]
+		x, y := side-1-previous[1], previous[0]
+		if t.states[r][y][x] {
+			t.specialAt[r] = [2]int{x, y}
+			continue
+		}
+		t.specialAt[r] = squareOfRank(t.states[r], rankOfSquare(t.states[r-1], previous))
+	}
+}
+
+// style of the square at a given position in the current rotation state
+func (t tetrisBlock) squareStyle(x, y int) int {
+	if t.special != noStyle && t.specialAt[t.r] == [2]int{x, y} {
+		return t.special
+	}
+	return t.style
+}
+
 // x and y are given in squares
 func (t tetrisBlock) isInValidPosition(grid tetrisGrid) bool {
 
@@ -87,7 +188,7 @@ func (t *tetrisBlock) moveRight(grid tetrisGrid) bool {
 	return true
 }
 
-func (b *tetrisBlock) updatePosition(rlMove int, dMove bool, grid tetrisGrid) (stuck bool, lrMoved bool) {
+func (b *tetrisBlock) updatePosition(rlMove int, dMoves int, grid tetrisGrid) (stuck bool, lrMoved bool) {
 
 	if rlMove < 0 {
 		lrMoved = b.moveLeft(grid)
@@ -97,9 +198,13 @@ func (b *tetrisBlock) updatePosition(rlMove int, dMove bool, grid tetrisGrid) (s
 		lrMoved = b.moveRight(grid)
 	}
 
-	// try to move down or detect that the block is stuck
-	if dMove {
-		stuck = b.moveDown(grid)
+	// try to move down or detect that the block is stuck,
+	// it is only stuck if it could not move at all
+	for row := 0; row < dMoves; row++ {
+		if b.moveDown(grid) {
+			stuck = row == 0
+			break
+		}
 	}
 
 	return
@@ -123,7 +228,65 @@ func (t *tetrisBlock) rotateRight(grid tetrisGrid) bool {
 	return true
 }
 
-func (t tetrisBlock) writeInGrid(grid *tetrisGrid) (toCheck [2]int) {
+// the block as seen in a mirror put at the right of a grid of a given width
+func (t tetrisBlock) mirrored(width int) tetrisBlock {
+	for r := range t.states {
+		for y := range t.states[r] {
+			line := t.states[r][y]
+			for x := range line {
+				t.states[r][y][blockSize-1-x] = line[x]
+			}
+		}
+		t.specialAt[r][0] = blockSize - 1 - t.specialAt[r][0]
+	}
+	t.x = width - blockSize - t.x
+	return t
+}
+
+// center of the squares of the block in the grid, in squares
+func (t tetrisBlock) center() (center [2]float64) {
+	squares := 0
+	for y, line := range t.states[t.r] {
+		for x, square := range line {
+			if square {
+				center[0] += float64(t.x + x)
+				center[1] += float64(t.y + y)
+				squares++
+			}
+		}
+	}
+	if squares > 0 {
+		center[0] = center[0]/float64(squares) + 0.5
+		center[1] = center[1]/float64(squares) + 0.5
+	}
+	return
+}
+
+// positions tried in order, from the current one, when a half turn is
+// blocked where it is and kicks are enabled (x to the right, y downward)
+var halfTurnKicks [][2]int = [][2]int{{0, -1}, {1, -1}, {-1, -1}, {1, 0}, {-1, 0}}
+
+func (t *tetrisBlock) rotateHalf(grid tetrisGrid, kicks bool) bool {
+	t.r = (t.r + 2) % 4
+	if t.isInValidPosition(grid) {
+		return true
+	}
+	if kicks {
+		for _, kick := range halfTurnKicks {
+			t.x += kick[0]
+			t.y += kick[1]
+			if t.isInValidPosition(grid) {
+				return true
+			}
+			t.x -= kick[0]
+			t.y -= kick[1]
+		}
+	}
+	t.r = (t.r + 2) % 4
+	return false
+}
+
+func (t tetrisBlock) writeInGrid(grid tetrisGrid) (toCheck [2]int) {
 
 	yMin := len(grid)
 	yMax := 0
@@ -133,7 +296,7 @@ func (t tetrisBlock) writeInGrid(grid *tetrisGrid) (toCheck [2]int) {
 		for xRel, square := range line {
 			if square {
 				xAbs := t.x + xRel
-				grid[yAbs][xAbs] = t.style
+				grid[yAbs][xAbs] = t.squareStyle(xRel, yRel)
 				if yAbs < yMin {
 					yMin = yAbs
 				}
@@ -149,6 +312,11 @@ func (t tetrisBlock) writeInGrid(grid *tetrisGrid) (toCheck [2]int) {
 
 // xFrom, yFrom in pixels
 func (t tetrisBlock) draw(screen *ebiten.Image, gray uint8, xFrom, yFrom int, scaling float64) {
+	t.drawWithAlpha(screen, gray, xFrom, yFrom, scaling, 1)
+}
+
+// xFrom, yFrom in pixels, alpha is the opacity of the block
+func (t tetrisBlock) drawWithAlpha(screen *ebiten.Image, gray uint8, xFrom, yFrom int, scaling float64, alpha float32) {
 
 	for yRel, line := range t.states[t.r] {
 		yAbs := t.y + yRel
@@ -156,16 +324,27 @@ func (t tetrisBlock) draw(screen *ebiten.Image, gray uint8, xFrom, yFrom int, sc
 			if square {
 				xAbs := t.x + xRel
 
-				options := ebiten.DrawImageOptions{}
-				options.ColorScale.ScaleWithColor(color.Gray{gray})
-				options.GeoM.Scale(scaling, scaling)
-				options.GeoM.Translate(float64(xFrom)+float64(xAbs*gSquareSideSize)*scaling, float64(yFrom)+float64(yAbs*gSquareSideSize)*scaling)
-				screen.DrawImage(assets.ImageSquares.SubImage(image.Rect((t.style-1)*gSquareSideSize, 0, t.style*gSquareSideSize, gSquareSideSize)).(*ebiten.Image), &options)
+				drawSquare(screen, t.squareStyle(xRel, yRel), float64(xFrom)+float64(xAbs*gSquareSideSize)*scaling, float64(yFrom)+float64(yAbs*gSquareSideSize)*scaling, scaling, gray, alpha)
 			}
 		}
 	}
 }
 
+// draw one square of a given style, x and y in pixels
+func drawSquare(screen *ebiten.Image, style int, x, y, scaling float64, gray uint8, alpha float32) {
+	if sprite, ok := styleSprites[style]; ok {
+		style = sprite.style
+		gray = uint8(int(gray) * int(sprite.gray) / 255)
+	}
+
+	options := ebiten.DrawImageOptions{}
+	options.ColorScale.ScaleWithColor(color.Gray{gray})
+	options.ColorScale.ScaleAlpha(alpha)
+	options.GeoM.Scale(scaling, scaling)
+	options.GeoM.Translate(x, y)
+	screen.DrawImage(assets.ImageSquares.SubImage(image.Rect((style-1)*gSquareSideSize, 0, style*gSquareSideSize, gSquareSideSize)).(*ebiten.Image), &options)
+}
+
 // check if
 func canReplace(atX, atY int, preferedBlock, otherBlock tetrisBlock, grid tetrisGrid) bool {
 
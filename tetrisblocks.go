@@ -29,13 +29,33 @@ const (
 	tBlockStyle
 	zBlockStyle
 	breakStyle
+	garbageStyle // lines sent by the opponent in versus mode
+	wallStyle    // indestructible squares of the columns filled by the shrink malus
+	bombStyle    // special square clearing the squares around it when its line is removed
+	coinStyle    // special square giving a coin when its line is removed
+	curseStyle   // special square costing a heart when its line is removed
 )
 
+// special squares that blocks may hold, in the order they are drawn at random
+var specialStyles []int = []int{bombStyle, coinStyle, curseStyle}
+
+// styles without a sprite of their own, drawn with the sprite of another style darkened
+var styleSprites map[int]struct {
+	style int
+	gray  uint8
+} = map[int]struct {
+	style int
+	gray  uint8
+}{
+	garbageStyle: {style: breakStyle, gray: 150},
+	wallStyle:    {style: breakStyle, gray: 60},
+}
+
 func getIBlock() tetrisBlock {
 	return tetrisBlock{
 		id:    2,
 		style: iBlockStyle,
-		states: [4][4][4]bool{
+		states: [4][blockSize][blockSize]bool{
 			{{false, false, false, false},
 				{false, false, false, false},
 				{true, true, true, true},
@@ -60,7 +80,7 @@ func getOBlock() tetrisBlock {
 	return tetrisBlock{
 		id:    3,
 		style: oBlockStyle,
-		states: [4][4][4]bool{
+		states: [4][blockSize][blockSize]bool{
 			{{false, false, false, false},
 				{false, true, true, false},
 				{false, true, true, false},
@@ -85,7 +105,7 @@ func getJBlock() tetrisBlock {
 	return tetrisBlock{
 		id:    1,
 		style: jBlockStyle,
-		states: [4][4][4]bool{
+		states: [4][blockSize][blockSize]bool{
 			{{false, false, false, false},
 				{true, true, true, false},
 				{false, false, true, false},
@@ -110,7 +130,7 @@ func getLBlock() tetrisBlock {
 	return tetrisBlock{
 		id:    0,
 		style: lBlockStyle,
-		states: [4][4][4]bool{
+		states: [4][blockSize][blockSize]bool{
 			{{false, false, false, false},
 				{true, true, true, false},
 				{true, false, false, false},
@@ -135,7 +155,7 @@ func getSBlock() tetrisBlock {
 	return tetrisBlock{
 		id:    5,
 		style: sBlockStyle,
-		states: [4][4][4]bool{
+		states: [4][blockSize][blockSize]bool{
 			{{false, false, false, false},
 				{false, true, true, false},
 				{true, true, false, false},
@@ -160,7 +180,7 @@ func getTBlock() tetrisBlock {
 	return tetrisBlock{
 		id:    6,
 		style: tBlockStyle,
-		states: [4][4][4]bool{
+		states: [4][blockSize][blockSize]bool{
 			{{false, false, false, false},
 				{true, true, true, false},
 				{false, true, false, false},
@@ -185,7 +205,7 @@ func getZBlock() tetrisBlock {
 	return tetrisBlock{
 		id:    4,
 		style: zBlockStyle,
-		states: [4][4][4]bool{
+		states: [4][blockSize][blockSize]bool{
 			{{false, false, false, false},
 				{true, true, false, false},
 				{false, true, true, false},
@@ -0,0 +1,62 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+// number of steps of the lines removal animation, spread over the line clear delay
+const removeLineAnimationSteps int = 8
+
+const (
+	classicTimingName string = "CLASSIC" // timing used when none is given
+	versusTimingName  string = "VERSUS"
+)
+
+// delays of a mode, in frames
+type timing struct {
+	name       string
+	lineClear  int // duration of the lines removal animation, lines vanish at once if 0
+	spawnDelay int // wait between the lock of a block and the appearance of the next one (ARE)
+}
+
+// timings of the modes, the one given with -timing replaces the default one of every mode
+var timings []timing = []timing{
+	{name: "CLASSIC", lineClear: 56},
+	{name: "VERSUS", lineClear: 40, spawnDelay: 6},
+	{name: "NES", lineClear: 18, spawnDelay: 10},
+	{name: "INSTANT"}, // for sprint play
+}
+
+// find a timing by its name, nil if it does not exist
+func getTiming(name string) *timing {
+	for i := range timings {
+		if timings[i].name == name {
+			return &timings[i]
+		}
+	}
+	return nil
+}
+
+// timing of a mode, unless another one was given on the command line
+func modeTiming(defaultName string) *timing {
+	if timingName != "" {
+		if t := getTiming(timingName); t != nil {
+			return t
+		}
+	}
+	return getTiming(defaultName)
+}
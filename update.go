@@ -36,9 +36,10 @@ func (g *game) Update() (err error) {
 		g.audio.UpdateMusic(0.7)
 	}
 
-	betterRotation := g.improv.levels[improveResetAutoDown] > 0
-	canHold := g.improv.levels[improveHold] > 0
-	life := g.improv.levels[improveLife]*2 - 1
+	effects := g.improv.getEffects()
+	if g.daily.playing {
+		effects = g.daily.effects
+	}
 
 	switch g.state {
 	case stateControls:
@@ -59,42 +60,119 @@ func (g *game) Update() (err error) {
 		if g.titleFrame >= numArrowBlinkFrame {
 			g.titleFrame = 0
 		}
+		if len(inpututil.AppendJustPressedKeys(nil)) > 0 {
+			g.idleFrames = 0
+		}
+		g.idleFrames++
+		if g.idleFrames >= attractDelayFrames {
+			g.startDemo()
+			return nil
+		}
 		if g.updateStateTitle() {
-			if g.titleSelect == 0 {
-				g.firstPlay = false
-				g.state = statePlay
-				g.balance = newBalance(g.numChoices)
-				g.currentPlay.init(g.level, g.balance, g.level, 0, betterRotation, canHold, life, life)
-				g.fog.reset(g.balance.getHiddenLines(), g.improv.levels[improveHideMove])
-			} else {
+			switch g.titleSelect {
+			case titlePlay:
+				g.state = stateModes
+			case titleCredits:
 				g.state = stateCredits
+			case titleDaily:
+				g.state = stateDaily
+			}
+		}
+	case stateModes:
+		g.titleFrame++
+		if g.titleFrame >= numArrowBlinkFrame {
+			g.titleFrame = 0
+		}
+		selected, back := g.updateStateModes()
+		if back {
+			g.state = stateTitle
+			g.idleFrames = 0
+		}
+		if !selected {
+			return nil
+		}
+		switch g.modeSelect {
+		case modeAdventure:
+			g.startAdventure(effects, nil)
+		case modePieces:
+			if len(pieceSets) > 0 {
+				g.startAdventure(effects, &pieceSets[g.pieceSetSelect])
 			}
+		case modeVersus:
+			g.state = stateVersus
+			g.versus = newVersus()
+		case modeCoop:
+			g.state = stateCoop
+			g.coop = newCoop(g.numChoices, g.goalLevel)
+		case modeOnline:
+			g.state = stateOnline
+			g.online = newNetVersus(dialRelay(relayAddress), relayRoom)
+		}
+	case stateDaily:
+		g.titleFrame++
+		if g.titleFrame >= numArrowBlinkFrame {
+			g.titleFrame = 0
+		}
+		if g.updateStateDaily() {
+			g.state = stateTitle
+			g.idleFrames = 0
+		}
+	case stateVersus:
+		quit, playSounds := g.versus.update()
+		g.audio.NextSounds = playSounds
+		if quit {
+			g.state = stateModes
+		}
+	case stateCoop:
+		quit, playSounds := g.coop.update()
+		g.audio.NextSounds = playSounds
+		if quit {
+			g.state = stateModes
+		}
+	case stateOnline:
+		quit, playSounds := g.online.update(g.inputs)
+		g.audio.NextSounds = playSounds
+		if quit {
+			g.state = stateModes
+			g.online = nil
 		}
 	case statePlay:
 		if g.updateStatePlay() {
-			g.state = stateLost
-			g.money.addScore(g.currentPlay.score)
+			if g.daily.playing {
+				g.endDaily(false)
+				return nil
+			}
+			g.endRun()
 		}
 		if !g.currentPlay.inAnimation && g.currentPlay.numLines >= g.balance.getGoalLines() {
-			if g.level+1 >= g.goalLevel {
+			if g.level+1 >= g.runGoalLevel && g.daily.playing {
+				g.audio.NextSounds[assets.SoundBuyID] = true
+				g.endDaily(true)
+				return nil
+			}
+			if g.level+1 >= g.runGoalLevel {
+				g.newTier = g.ascension.win()
+				g.winFrame = 0
 				g.state = stateWon
 				g.audio.NextSounds[assets.SoundBuyID] = true
 				g.audio.StopMusic()
 				return nil
 			}
 			g.state = stateBalance
-			g.balance.getChoice()
+			g.balance.startDraft(g.level, g.money.money)
 		}
 	case stateBalance:
-		finished, playSounds := g.balance.update()
+		finished, playSounds := g.balance.update(&g.money.money)
 		g.audio.NextSounds = playSounds
 		if finished {
 			g.state = statePlay
 			g.level++
-			g.currentPlay.init(g.level, g.balance, g.level, g.currentPlay.score, betterRotation, canHold, life, g.currentPlay.currentLife)
-			g.fog.reset(g.balance.getHiddenLines(), g.improv.levels[improveHideMove])
+			effects = g.balance.applyBoons(effects)
+			g.currentPlay.init(g.level, g.balance, g.level, g.currentPlay.score, effects, g.currentPlay.currentLife)
+			g.currentPlay.removeBottomLines(g.balance.getBombLines())
+			g.fog.reset(g.balance, effects.fogProtection)
 		}
-	case stateLost:
+	case stateRunEnd:
 		finished, playSounds := g.money.update()
 		g.audio.NextSounds = playSounds
 		if finished {
@@ -108,6 +186,12 @@ func (g *game) Update() (err error) {
 			g.state = stateTitle
 			g.titleFrame = 0
 		}
+	case stateDemo:
+		if g.updateStateDemo() {
+			g.state = stateTitle
+			g.titleFrame = 0
+			g.idleFrames = 0
+		}
 	case stateWon:
 		g.winFrame++
 		if g.winFrame >= len(gAnimRocket) {
@@ -117,16 +201,41 @@ func (g *game) Update() (err error) {
 		if g.winFrame == 16 {
 			g.audio.NextSounds[assets.SoundRocketID] = true
 		}
+		if g.inputs.enter {
+			g.audio.NextSounds[assets.SoundMenuConfirmID] = true
+			g.endRun()
+		}
 	}
 
 	return nil
 }
 
+// end of a run of the adventure, lost or won: the score is converted into coins for the shop
+func (g *game) endRun() {
+	g.state = stateRunEnd
+	g.money.addScore(g.currentPlay.score, g.balance.getMultiplier()*g.runCoinRate/100)
+}
+
 func (g *game) updateStateTitle() (end bool) {
-	if g.inputs.right || g.inputs.down || g.inputs.left || g.inputs.up {
+	// left/right choose the ascension tier on play, once a tier is unlocked
+	if g.titleSelect == titlePlay && g.ascension.unlocked > 0 && (g.inputs.left || g.inputs.right) {
+		g.audio.NextSounds[assets.SoundMenuMoveID] = true
+		step := 1
+		if g.inputs.left {
+			step = -1
+		}
+		g.ascension.move(step)
+		return g.inputs.enter
+	}
+
+	if g.inputs.right || g.inputs.down {
 		// inpututil.IsKeyJustPressed(ebiten.KeyRight) || inpututil.IsKeyJustPressed(ebiten.KeyDown) || inpututil.IsKeyJustPressed(ebiten.KeyLeft) || inpututil.IsKeyJustPressed(ebiten.KeyUp)
 		g.audio.NextSounds[assets.SoundMenuMoveID] = true
-		g.titleSelect = (g.titleSelect + 1) % 2
+		g.titleSelect = (g.titleSelect + 1) % numTitleEntries
+	}
+	if g.inputs.left || g.inputs.up {
+		g.audio.NextSounds[assets.SoundMenuMoveID] = true
+		g.titleSelect = (g.titleSelect + numTitleEntries - 1) % numTitleEntries
 	}
 
 	//end = inpututil.IsKeyJustPressed(ebiten.KeyEnter)
@@ -135,7 +244,60 @@ func (g *game) updateStateTitle() (end bool) {
 	return
 }
 
+// start a run of the adventure, with the tetrominoes if no piece set is given
+func (g *game) startAdventure(effects improvementEffects, set *pieceSet) {
+	g.firstPlay = false
+	g.state = statePlay
+	rules := g.ascension.rules()
+	g.balance = newBalance(max(1, g.numChoices-rules.choices), effects)
+	for _, malus := range rules.maluses {
+		g.balance.imposeMalus(malus)
+	}
+	g.runGoalLevel = g.goalLevel + rules.levels
+	g.runCoinRate = 100 - rules.coins
+	g.currentPlay.width = boardSizes[g.boardSelect].width
+	g.currentPlay.height = boardSizes[g.boardSelect].height
+	g.currentPlay.pieceSet = set
+	g.currentPlay.timing = modeTiming(classicTimingName)
+	g.currentPlay.cascade = cascadeGravity
+	g.currentPlay.init(g.level, g.balance, g.level, 0, effects, effects.life)
+	g.fog.reset(g.balance, effects.fogProtection)
+}
+
+// start a game played by the bot, while the title screen is inactive
+func (g *game) startDemo() {
+	g.state = stateDemo
+	g.level = 0
+	effects := improvementEffects{life: -1}
+	g.balance = newBalance(g.numChoices, effects)
+	g.currentPlay.width = gPlayAreaWidthInBlocks
+	g.currentPlay.height = gPlayAreaHeightInBlocks
+	g.currentPlay.pieceSet = nil
+	g.currentPlay.timing = getTiming(classicTimingName)
+	g.currentPlay.cascade = false
+	// the keys held at the end of the last run must not act on the blocks of the bot
+	g.currentPlay.initialActions = initialActions{}
+	g.currentPlay.init(g.level, g.balance, g.level, 0, effects, effects.life)
+	g.fog.reset(g.balance, effects.fogProtection)
+	g.bot = newBot(defaultBotHeuristic)
+}
+
+// the demo ends when any key is pressed or when the bot loses
+func (g *game) updateStateDemo() bool {
+	if len(inpututil.AppendJustPressedKeys(nil)) > 0 {
+		g.audio.NextSounds[assets.SoundMenuConfirmID] = true
+		return true
+	}
+
+	inputs := g.bot.update(g.currentPlay)
+	g.currentPlay.update(inputs.down, inputs.left, inputs.right, inputs.hold, inputs.rotateLeft, inputs.rotateRight, false, false, g.level)
+	g.fog.update(g.currentPlay.currentBlock)
+
+	return g.currentPlay.dead && !g.currentPlay.inAnimation
+}
+
 func (g *game) updateStatePlay() bool {
+	g.currentPlay.initialActions = g.inputs.initialActions()
 	sounds := g.currentPlay.update(
 		g.inputs.down,
 		g.inputs.left,
@@ -143,6 +305,8 @@ func (g *game) updateStatePlay() bool {
 		g.inputs.up,
 		g.inputs.alt,
 		g.inputs.space,
+		g.inputs.half,
+		g.inputs.undo,
 		//ebiten.IsKeyPressed(ebiten.KeyDown),
 		//ebiten.IsKeyPressed(ebiten.KeyLeft),
 		//ebiten.IsKeyPressed(ebiten.KeyRight),
@@ -153,8 +317,9 @@ func (g *game) updateStatePlay() bool {
 	)
 
 	g.audio.NextSounds = sounds
+	g.money.addCoins(g.currentPlay.takeCoins())
 
-	g.fog.update()
+	g.fog.update(g.currentPlay.currentBlock)
 
 	return g.currentPlay.dead && !g.currentPlay.inAnimation
 }
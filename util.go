@@ -19,10 +19,15 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"fmt"
 	"image"
 	"image/color"
+	"math/rand"
+	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
 	"github.com/loig/ebitenginegamejam2024/assets"
 )
 
@@ -67,8 +72,120 @@ func drawNumberAt(screen *ebiten.Image, gray uint8, x, y int, num int, over int)
 
 }
 
+// format a multiplier given in percent, e.g. X1.25
+func formatMultiplier(percent int) string {
+	return fmt.Sprintf("X%d.%02d", percent/100, percent%100)
+}
+
+// get a random number generator seeded with the current time
+func newRandom() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
 // remove one element from a slice of int
 func removeElement(t []int, pos int) []int {
 	t[pos], t[len(t)-1] = t[len(t)-1], t[pos]
 	return t[:len(t)-1]
 }
+
+// colors used when drawing text without a dedicated image
+var (
+	gTextColor       = color.RGBA{0x4f, 0x1a, 0x4f, 0xff}
+	gTextLightColor  = color.RGBA{0xf8, 0xf3, 0xd4, 0xff}
+	gTextBannerColor = color.RGBA{0xf2, 0xcf, 0x8f, 0xff}
+	gBoonColor       = color.RGBA{0xd4, 0xf0, 0xb4, 0xff}
+)
+
+// darken a color as ColorScale.ScaleWithColor(color.Gray{gray}) would do for images
+func scaleColor(clr color.RGBA, gray uint8) color.RGBA {
+	scale := func(v uint8) uint8 {
+		return uint8(int(v) * int(gray) / 255)
+	}
+	return color.RGBA{scale(clr.R), scale(clr.G), scale(clr.B), clr.A}
+}
+
+// maximum number of rendered texts kept, texts built with live values
+// (scores, money, counters) would otherwise fill the cache for the whole session
+const maxTextImages int = 256
+
+// a rendered text and when it was last drawn
+type textImage struct {
+	image *ebiten.Image
+	used  int
+}
+
+// cache of rendered texts, to avoid rendering them at each frame,
+// the least recently drawn text is removed when the cache is full
+var (
+	textImages map[string]*textImage = make(map[string]*textImage)
+	textUses   int
+)
+
+// get the rendered text from the cache, or render it
+func getTextImage(str string) *ebiten.Image {
+	textUses++
+	if cached, ok := textImages[str]; ok {
+		cached.used = textUses
+		return cached.image
+	}
+
+	if len(textImages) >= maxTextImages {
+		oldest, oldestUse := "", textUses
+		for key, cached := range textImages {
+			if cached.used < oldestUse {
+				oldest, oldestUse = key, cached.used
+			}
+		}
+		textImages[oldest].image.Deallocate()
+		delete(textImages, oldest)
+	}
+
+	width, height := textSize(str, 1)
+	image := ebiten.NewImage(width+1, height)
+	ebitenutil.DebugPrint(image, str)
+	textImages[str] = &textImage{image: image, used: textUses}
+	return image
+}
+
+// size in pixels of a text drawn with drawText
+func textSize(str string, scale float64) (width, height int) {
+	lineWidth := 0
+	numLines := 1
+	for _, c := range str {
+		if c == '\n' {
+			numLines++
+			lineWidth = 0
+			continue
+		}
+		lineWidth++
+		if lineWidth > width {
+			width = lineWidth
+		}
+	}
+	return int(float64(width*gTextCharWidth) * scale), int(float64(numLines*gTextCharHeight) * scale)
+}
+
+// draw a text which top left corner is given by (x, y) in pixels
+func drawText(screen *ebiten.Image, str string, x, y int, scale float64, clr color.Color) {
+
+	textImage := getTextImage(str)
+
+	options := ebiten.DrawImageOptions{}
+	options.ColorScale.ScaleWithColor(clr)
+	options.GeoM.Scale(scale, scale)
+	options.GeoM.Translate(float64(x), float64(y))
+	screen.DrawImage(textImage, &options)
+}
+
+// draw a text centered on (x, y) in pixels
+func drawTextCentered(screen *ebiten.Image, str string, x, y int, scale float64, clr color.Color) {
+	width, height := textSize(str, scale)
+	drawText(screen, str, x-width/2, y-height/2, scale, clr)
+}
+
+// draw a text in a banner which top left corner is given by (x, y) in pixels,
+// used when no image of the text exists
+func drawTextBanner(screen *ebiten.Image, str string, x, y, width, height int) {
+	vector.DrawFilledRect(screen, float32(x), float32(y), float32(width), float32(height), gTextBannerColor, false)
+	drawTextCentered(screen, str, x+width/2, y+height/2, gTextScale, gTextColor)
+}
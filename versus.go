@@ -0,0 +1,316 @@
+/*
+A game for Ebitengine game jam 2024
+
+# Copyright (C) 2024 Loïg Jezequel
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"image/color"
+	"math/rand"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"github.com/loig/ebitenginegamejam2024/assets"
+)
+
+// steps of a versus match
+const (
+	versusSetup int = iota
+	versusPlay
+	versusOver
+)
+
+const (
+	versusNumPlayers         int     = 2
+	versusMaxHandicap        int     = 6   // maximum number of malus levels given as handicap
+	versusLinesPerSpeedLevel int     = 10  // lines to clear for increasing the speed
+	versusScale              float64 = 0.5 // scaling of the play area of each player
+)
+
+// garbage lines sent for a number of lines cleared at once
+var versusGarbage [5]int = [5]int{0, 0, 1, 2, 4}
+
+// maluses that can be given as handicap
+var versusHandicapMaluses []int = []int{
+	balanceSpeed, balanceHiddenLines, balanceDeathLines, balanceInvisibleBlocks,
+}
+
+var gGarbageColor color.RGBA = color.RGBA{0xb8, 0x3a, 0x4f, 0xff}
+
+type versusPlayer struct {
+	play     tetris
+	balance  balancing
+	fog      fog
+	inputs   KeyboardInputs
+	handicap int
+	lines    int // lines already taken into account for sending garbage
+	wins     int
+	ready    bool
+}
+
+type versus struct {
+	state       int
+	players     [versusNumPlayers]versusPlayer
+	names       [versusNumPlayers]string
+	overText    string                // controls displayed at the end of a match
+	timing      *timing               // delays of both players
+	cascade     bool                  // cascade gravity for both players
+	garbageSent [versusNumPlayers]int // total number of garbage lines sent by each player
+	decided     bool                  // one of the players lost
+	winner      int                   // -1 for a draw
+	screen      *ebiten.Image         // offscreen image for drawing the play area of one player
+}
+
+func newVersus() (v versus) {
+	v.players[0].inputs = KeyboardInputs{kmap: versusLeftKeys}
+	v.players[1].inputs = KeyboardInputs{kmap: versusRightKeys}
+	for i := range v.names {
+		v.names[i] = fmt.Sprintf("PLAYER %d", i+1)
+	}
+	v.overText = "ENTER: REMATCH   ESC/BACKSPACE: QUIT"
+	v.timing = modeTiming(versusTimingName)
+	v.cascade = cascadeGravity
+	return
+}
+
+// set up the tetris games of both players, with the same blocks sequence,
+// everything in a match only depends on the seed and the inputs of the players,
+// the garbage holes are drawn apart from the blocks to keep their sequences equal
+func (v *versus) start(seed int64) {
+	effects := improvementEffects{life: -1, canHold: true}
+
+	for i := range v.players {
+		p := &v.players[i]
+		p.balance = newBalance(0, effects)
+		p.balance.rng = rand.New(rand.NewSource(seed + int64(i) + 1))
+		for h := 0; h < p.handicap; h++ {
+			possible := make([]int, 0, len(versusHandicapMaluses))
+			for _, malus := range versusHandicapMaluses {
+				if p.balance.levels[malus] < p.balance.maxLevels[malus] {
+					possible = append(possible, malus)
+				}
+			}
+			if len(possible) > 0 {
+				p.balance.setChoice(possible[p.balance.rng.Intn(len(possible))])
+			}
+		}
+		p.play = tetris{rng: rand.New(rand.NewSource(seed)), garbageRng: rand.New(rand.NewSource(seed - int64(i) - 1)), gravityCurve: getGravityCurve(versusGravityName), timing: v.timing, cascade: v.cascade}
+		p.play.init(0, p.balance, 0, 0, effects, effects.life)
+		p.fog.reset(p.balance, 0)
+		p.lines = 0
+		p.ready = false
+	}
+
+	v.state = versusPlay
+	v.garbageSent = [versusNumPlayers]int{}
+	v.decided = false
+	v.winner = -1
+}
+
+// update the versus mode, returns true when going back to the title screen
+func (v *versus) update() (quit bool, playSounds [assets.NumSounds]bool) {
+	assignGamepads([]*KeyboardInputs{&v.players[0].inputs, &v.players[1].inputs})
+	for i := range v.players {
+		v.players[i].inputs.update()
+	}
+
+	switch v.state {
+	case versusSetup:
+		return v.updateSetup()
+	case versusPlay:
+		playSounds = v.updatePlay()
+	case versusOver:
+		for _, p := range v.players {
+			if p.inputs.undo {
+				playSounds[assets.SoundMenuNoID] = true
+				return true, playSounds
+			}
+			if p.inputs.enter {
+				playSounds[assets.SoundMenuConfirmID] = true
+				v.state = versusSetup
+			}
+		}
+	}
+
+	return
+}
+
+// each player chooses a handicap and says when ready
+func (v *versus) updateSetup() (quit bool, playSounds [assets.NumSounds]bool) {
+	allReady := true
+	for i := range v.players {
+		p := &v.players[i]
+		if p.inputs.undo {
+			playSounds[assets.SoundMenuNoID] = true
+			return true, playSounds
+		}
+		if !p.ready && p.inputs.menuLeft && p.handicap > 0 {
+			p.handicap--
+			playSounds[assets.SoundMenuMoveID] = true
+		}
+		if !p.ready && p.inputs.menuRight && p.handicap < versusMaxHandicap {
+			p.handicap++
+			playSounds[assets.SoundMenuMoveID] = true
+		}
+		if p.inputs.enter {
+			p.ready = !p.ready
+			playSounds[assets.SoundMenuConfirmID] = true
+		}
+		allReady = allReady && p.ready
+	}
+
+	if allReady {
+		v.start(time.Now().UnixNano())
+	}
+
+	return
+}
+
+// play one frame for both players and exchange garbage
+func (v *versus) updatePlay() (playSounds [assets.NumSounds]bool) {
+	for i := range v.players {
+		p := &v.players[i]
+		level := p.play.numLines / versusLinesPerSpeedLevel
+		p.play.initialActions = p.inputs.initialActions()
+		sounds := p.play.update(
+			p.inputs.down,
+			p.inputs.left,
+			p.inputs.right,
+			p.inputs.up,
+			p.inputs.alt,
+			p.inputs.space,
+			p.inputs.half,
+			false,
+			level,
+		)
+		for sound, play := range sounds {
+			playSounds[sound] = playSounds[sound] || play
+		}
+		p.fog.update(p.play.currentBlock)
+
+		if cleared := p.play.numLines - p.lines; cleared > 0 {
+			p.lines = p.play.numLines
+			p.play.gravity = p.play.gravityCurve.at(p.balance.getSpeedLevel(p.play.numLines / versusLinesPerSpeedLevel))
+			sent := versusGarbage[min(cleared, len(versusGarbage)-1)]
+			cancelled := min(sent, p.play.pendingGarbage)
+			p.play.pendingGarbage -= cancelled
+			v.players[1-i].play.pendingGarbage += sent - cancelled
+			v.garbageSent[i] += sent
+		}
+	}
+
+	// the first player to lose gives the victory to the other one
+	if !v.decided && (v.players[0].play.dead || v.players[1].play.dead) {
+		v.decided = true
+		v.winner = -1
+		for i, p := range v.players {
+			if !p.play.dead {
+				v.winner = i
+				v.players[i].wins++
+			}
+		}
+	}
+
+	if v.decided {
+		for _, p := range v.players {
+			if p.play.dead && p.play.inAnimation {
+				return
+			}
+		}
+		v.state = versusOver
+	}
+
+	return
+}
+
+// hash of the state of both games, for detecting desynchronizations
+func (v versus) hash() uint64 {
+	h := fnv.New64a()
+	for _, p := range v.players {
+		for _, line := range p.play.area {
+			for _, style := range line {
+				h.Write([]byte{byte(style)})
+			}
+		}
+		block := p.play.currentBlock
+		binary.Write(h, binary.LittleEndian, [...]int64{
+			int64(block.x), int64(block.y), int64(block.r), int64(block.id),
+			int64(p.play.score), int64(p.play.numLines), int64(p.play.pendingGarbage),
+		})
+	}
+	return h.Sum64()
+}
+
+func (v *versus) draw(screen *ebiten.Image) {
+	screen.Fill(gTextColor)
+
+	if v.screen == nil {
+		v.screen = ebiten.NewImage(gWidth, gHeight)
+	}
+
+	gray := uint8(255)
+	if v.state != versusPlay {
+		gray = 100
+	}
+
+	yBoard := (float64(gHeight) - float64(gHeight)*versusScale) / 2
+	for i, p := range v.players {
+		v.screen.Clear()
+		drawPlayArea(v.screen, p.play, p.fog, gray)
+		drawNumberAt(v.screen, gray, gWidth-gXLinesFromRightSide+gMultFactor, gYLinesFromTop, p.play.numLines, -1)
+		drawNumberAt(v.screen, gray, gWidth-gXScoreFromRightSide+gMultFactor, gYScoreFromTop, p.play.score, -1)
+		drawNumberAt(v.screen, gray, gWidth-gXLevelFromRightSide+gMultFactor, gYLevelFromTop, p.play.numLines/versusLinesPerSpeedLevel+1, -1)
+		if p.play.pendingGarbage > 0 {
+			height := float32(min(p.play.pendingGarbage, gPlayAreaHeightInBlocks) * gSquareSideSize)
+			vector.DrawFilledRect(v.screen, float32(gPlayAreaSide/4), float32(gHeight)-height, float32(gPlayAreaSide/2), height, gGarbageColor, false)
+		}
+
+		options := ebiten.DrawImageOptions{}
+		options.GeoM.Scale(versusScale, versusScale)
+		options.GeoM.Translate(float64(i*gWidth)*versusScale, yBoard)
+		screen.DrawImage(v.screen, &options)
+
+		xCenter := int((float64(i) + 0.5) * float64(gWidth) * versusScale)
+		drawTextCentered(screen, v.names[i], xCenter, int(yBoard)/3, 1.5*gTextScale, gTextLightColor)
+		drawTextCentered(screen, fmt.Sprintf("WINS %d   HANDICAP %d", p.wins, p.handicap), xCenter, 2*int(yBoard)/3, gTextScale, gTextLightColor)
+		if v.state == versusSetup {
+			status := "< HANDICAP >\nENTER WHEN READY"
+			if p.ready {
+				status = "READY"
+			}
+			drawTextCentered(screen, status, xCenter, gHeight/2, gTextScale, gTextLightColor)
+		}
+	}
+
+	controls := "LEFT: WASD, Q/E ROTATE, R HALF TURN, TAB READY, ESC QUIT\nRIGHT: ARROWS, ,/. ROTATE, / HALF TURN, ENTER READY, BACKSPACE QUIT"
+	switch v.state {
+	case versusOver:
+		result := "DRAW"
+		if v.winner >= 0 {
+			result = v.names[v.winner] + " WINS"
+		}
+		drawTextBanner(screen, result, (gWidth-gTextMalusWidth)/2, gHeight/2-gTextMalusHeight/2, gTextMalusWidth, gTextMalusHeight)
+		controls = v.overText
+	case versusPlay:
+		controls = ""
+	}
+	drawTextCentered(screen, controls, gWidth/2, gHeight-int(yBoard)/2, gTextScale/1.5, gTextLightColor)
+}